@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Units reported by go test -bench -benchmem that are compared.
+const (
+	NsPerOp     = "ns/op"
+	BytesPerOp  = "B/op"
+	AllocsPerOp = "allocs/op"
+)
+
+// Result is one benchmark line.  Running with -count gives several results
+// for the same name.
+type Result struct {
+	// Qualified by package, such as "foo/bar.BenchmarkBaz-8".
+	Name       string
+	Iterations int
+	Values     map[string]float64
+}
+
+// Parse the output of go test -bench, ignoring anything that is not a
+// benchmark result.
+func Parse(r io.Reader) ([]*Result, error) {
+	results := []*Result{}
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "pkg: ") {
+			pkg = strings.TrimSpace(line[len("pkg: "):])
+			continue
+		}
+		result := parseLine(line)
+		if result == nil {
+			continue
+		}
+		if pkg != "" {
+			result.Name = pkg + "." + result.Name
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+func parseLine(line string) *Result {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || len(fields)%2 != 0 {
+		return nil
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil
+	}
+	result := &Result{Name: fields[0], Iterations: iterations, Values: map[string]float64{}}
+	for i := 2; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil
+		}
+		result.Values[fields[i+1]] = value
+	}
+	return result
+}
+
+// Set gathers the samples for each benchmark and unit.
+type Set map[string]map[string][]float64
+
+func MakeSet(results []*Result) Set {
+	set := Set{}
+	for _, result := range results {
+		units := set[result.Name]
+		if units == nil {
+			units = map[string][]float64{}
+			set[result.Name] = units
+		}
+		for unit, value := range result.Values {
+			units[unit] = append(units[unit], value)
+		}
+	}
+	return set
+}
@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: example.com/foo
+BenchmarkParse-8   	  100000	     12345 ns/op	     512 B/op	       7 allocs/op
+BenchmarkParse-8   	  100000	     12000 ns/op	     512 B/op	       7 allocs/op
+BenchmarkFast-8    	1000000000	         0.2500 ns/op	       0 B/op	       0 allocs/op
+BenchmarkBroken-8  	--- FAIL: BenchmarkBroken
+PASS
+ok  	example.com/foo	3.210s
+`
+
+func TestParse(t *testing.T) {
+	results, err := Parse(strings.NewReader(output))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, "example.com/foo.BenchmarkParse-8", results[0].Name)
+	assert.Equal(t, 100000, results[0].Iterations)
+	assert.Equal(t, 12345.0, results[0].Values[NsPerOp])
+	assert.Equal(t, 512.0, results[0].Values[BytesPerOp])
+	assert.Equal(t, 7.0, results[0].Values[AllocsPerOp])
+	assert.Equal(t, 0.25, results[2].Values[NsPerOp])
+
+	set := MakeSet(results)
+	assert.Equal(t, []float64{12345, 12000}, set["example.com/foo.BenchmarkParse-8"][NsPerOp])
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	// Completely separated samples.
+	p := MannWhitneyU([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10})
+	assert.True(t, p < 0.05, p)
+
+	// Interleaved samples.
+	p = MannWhitneyU([]float64{1, 3, 5, 7, 9}, []float64{2, 4, 6, 8, 10})
+	assert.True(t, p > 0.5, p)
+
+	// Identical samples.
+	assert.Equal(t, 1.0, MannWhitneyU([]float64{3, 3, 3}, []float64{3, 3, 3}))
+	assert.Equal(t, 1.0, MannWhitneyU(nil, []float64{1}))
+}
+
+func makeSet(name string, ns ...float64) Set {
+	return Set{name: {NsPerOp: ns, AllocsPerOp: {1, 1, 1, 1, 1}}}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := makeSet("BenchmarkA", 100, 101, 99, 100, 102)
+
+	comparisons := Compare(baseline, makeSet("BenchmarkA", 130, 131, 129, 132, 130), 0.1)
+	assert.Equal(t, 2, len(comparisons))
+	assert.Equal(t, NsPerOp, comparisons[0].Unit)
+	assert.True(t, comparisons[0].Regressed)
+	assert.InDelta(t, 0.3, comparisons[0].Delta, 0.01)
+	assert.False(t, comparisons[1].Regressed)
+
+	// Significant, but within the threshold.
+	comparisons = Compare(baseline, makeSet("BenchmarkA", 105, 106, 104, 105, 107), 0.1)
+	assert.False(t, comparisons[0].Regressed)
+
+	// Big, but too noisy to be significant.
+	comparisons = Compare(baseline, makeSet("BenchmarkA", 90, 300, 95, 98, 200), 0.1)
+	assert.False(t, comparisons[0].Regressed)
+
+	// Faster is never a regression.
+	comparisons = Compare(baseline, makeSet("BenchmarkA", 50, 51, 49, 50, 52), 0.1)
+	assert.False(t, comparisons[0].Regressed)
+
+	// New benchmarks have nothing to compare against.
+	assert.Equal(t, 0, len(Compare(baseline, makeSet("BenchmarkB", 1), 0.1)))
+}
+
+func TestStore(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	run, err := s.LatestRun()
+	assert.NoError(t, err)
+	assert.Nil(t, run)
+	baseline, err := s.Baseline()
+	assert.NoError(t, err)
+	assert.Nil(t, baseline)
+
+	results, _ := Parse(strings.NewReader(output))
+	assert.NoError(t, s.SaveRun(&Run{ID: "1", Results: results[:1]}))
+	assert.NoError(t, s.SaveRun(&Run{ID: "2", Results: results}))
+	run, err = s.LatestRun()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(run.Results))
+
+	assert.NoError(t, s.SaveBaseline(run))
+	baseline, err = s.Baseline()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", baseline.ID)
+}
+
+func TestStoreRetention(t *testing.T) {
+	s := &Store{Dir: t.TempDir(), MaxRuns: 2}
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, s.SaveRun(&Run{ID: id}))
+	}
+	assert.NoError(t, s.SaveBaseline(&Run{ID: "1"}))
+	names, err := s.runPaths()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(names))
+	run, err := s.LatestRun()
+	assert.NoError(t, err)
+	assert.Equal(t, "3", run.ID)
+	// The baseline outlives the run it came from.
+	baseline, err := s.Baseline()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", baseline.ID)
+
+	unlimited := &Store{Dir: s.Dir, MaxRuns: -1}
+	for _, id := range []string{"4", "5", "6"} {
+		assert.NoError(t, unlimited.SaveRun(&Run{ID: id}))
+	}
+	names, err = s.runPaths()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(names))
+}
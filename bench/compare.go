@@ -0,0 +1,122 @@
+package bench
+
+import (
+	"math"
+	"sort"
+)
+
+// Comparison of one benchmark and unit between the baseline and a new run.
+type Comparison struct {
+	Name string
+	Unit string
+	Old  float64
+	New  float64
+	// Relative change of the mean, positive is slower or bigger.
+	Delta float64
+	// Chance of a difference at least this big if nothing changed.
+	P float64
+	// Significantly worse by more than the threshold.
+	Regressed bool
+}
+
+// Differences with a higher P value are treated as noise.
+const Alpha = 0.05
+
+func mean(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// Compare benchmarks present in both sets.  A benchmark regresses when its
+// mean gets worse by more than threshold, such as 0.1 for 10%, and the
+// Mann-Whitney U test says the difference is unlikely to be noise.
+func Compare(baseline Set, current Set, threshold float64) []*Comparison {
+	names := []string{}
+	for name := range current {
+		if baseline[name] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	comparisons := []*Comparison{}
+	for _, name := range names {
+		for _, unit := range []string{NsPerOp, BytesPerOp, AllocsPerOp} {
+			old := baseline[name][unit]
+			cur := current[name][unit]
+			if len(old) == 0 || len(cur) == 0 {
+				continue
+			}
+			c := &Comparison{Name: name, Unit: unit, Old: mean(old), New: mean(cur)}
+			switch {
+			case c.Old != 0:
+				c.Delta = (c.New - c.Old) / c.Old
+			case c.New != 0:
+				c.Delta = math.Inf(1)
+			}
+			c.P = MannWhitneyU(old, cur)
+			c.Regressed = c.Delta > threshold && c.P <= Alpha
+			comparisons = append(comparisons, c)
+		}
+	}
+	return comparisons
+}
+
+// Two-sided p value of the Mann-Whitney U test, using the normal
+// approximation with a correction for ties.
+func MannWhitneyU(a []float64, b []float64) float64 {
+	n1 := float64(len(a))
+	n2 := float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	type sample struct {
+		value float64
+		fromA bool
+	}
+	samples := []sample{}
+	for _, v := range a {
+		samples = append(samples, sample{v, true})
+	}
+	for _, v := range b {
+		samples = append(samples, sample{v, false})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+
+	// Tied values share the average of their ranks.
+	rankA := 0.0
+	tieTerm := 0.0
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if samples[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	u := rankA - n1*(n1+1)/2
+	n := n1 + n2
+	mu := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := math.Abs(u-mu) - 0.5
+	if z < 0 {
+		z = 0
+	}
+	z /= math.Sqrt(variance)
+	return math.Erfc(z / math.Sqrt2)
+}
@@ -0,0 +1,194 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ncbray/crank/task"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Run struct {
+	ID      string
+	Time    time.Time
+	Results []*Result
+}
+
+// How many runs a Store keeps by default.
+const DefaultMaxRuns = 100
+
+// Store keeps the results of recent runs, and the baseline they are
+// compared against, as JSON files.  Runs beyond MaxRuns are deleted, oldest
+// first.  Zero means the default, negative means no limit.
+type Store struct {
+	Dir     string
+	MaxRuns int
+}
+
+func writeJSON(path string, value interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+func readRun(path string) (*Run, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	run := &Run{}
+	return run, json.Unmarshal(data, run)
+}
+
+func (s *Store) runsDir() string {
+	return filepath.Join(s.Dir, "runs")
+}
+
+func (s *Store) baselinePath() string {
+	return filepath.Join(s.Dir, "baseline.json")
+}
+
+// Paths of the saved runs, oldest first.
+func (s *Store) runPaths() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.runsDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) SaveRun(run *Run) error {
+	err := writeJSON(filepath.Join(s.runsDir(), run.ID+".json"), run)
+	if err != nil {
+		return err
+	}
+	return s.Prune()
+}
+
+// Delete the oldest runs beyond MaxRuns.  The baseline is kept separately,
+// so it survives.
+func (s *Store) Prune() error {
+	max := s.MaxRuns
+	if max == 0 {
+		max = DefaultMaxRuns
+	}
+	if max < 0 {
+		return nil
+	}
+	names, err := s.runPaths()
+	if err != nil {
+		return err
+	}
+	for len(names) > max {
+		err := os.Remove(names[0])
+		if err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// The most recent run, or nil if there are none.
+func (s *Store) LatestRun() (*Run, error) {
+	names, err := s.runPaths()
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	return readRun(names[len(names)-1])
+}
+
+// The baseline, or nil if there is none yet.
+func (s *Store) Baseline() (*Run, error) {
+	run, err := readRun(s.baselinePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return run, err
+}
+
+func (s *Store) SaveBaseline(run *Run) error {
+	return writeJSON(s.baselinePath(), run)
+}
+
+// Task runs benchmarks and fails if they regress from the baseline.  The
+// first run becomes the baseline.
+type Task struct {
+	Package string
+	// Which benchmarks to run, "." for all of them.
+	Pattern string
+	// More runs give the comparison more samples to work with.
+	Count int
+	// How much worse a benchmark may get, such as 0.1 for 10%.
+	Threshold float64
+	Store     *Store
+	Env       []string
+}
+
+func (t *Task) Command() *task.CommandTask {
+	return &task.CommandTask{
+		Args: []string{"go", "test", "-run", "^$", "-bench", t.Pattern, "-benchmem", "-count", strconv.Itoa(t.Count), t.Package},
+		Env:  t.Env,
+	}
+}
+
+func formatComparison(c *Comparison) string {
+	return fmt.Sprintf("%s %s: %.4g -> %.4g (%+.1f%%, p=%.3f)", c.Name, c.Unit, c.Old, c.New, c.Delta*100, c.P)
+}
+
+func (t *Task) Run(log task.TaskLog) bool {
+	capture := &task.CaptureLog{}
+	if !t.Command().Run(task.MakeMultiLog(log, capture)) {
+		return false
+	}
+	results, err := Parse(strings.NewReader(capture.Stdout.String()))
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+	now := time.Now()
+	run := &Run{ID: now.UTC().Format("20060102-150405.000"), Time: now, Results: results}
+	err = t.Store.SaveRun(run)
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+
+	baseline, err := t.Store.Baseline()
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+	if baseline == nil {
+		err = t.Store.SaveBaseline(run)
+		if err != nil {
+			log.LogError("%s", err)
+			return false
+		}
+		log.LogInfo("Saved baseline of %d results", len(results))
+		return true
+	}
+
+	ok := true
+	for _, c := range Compare(MakeSet(baseline.Results), MakeSet(results), t.Threshold) {
+		switch {
+		case c.Regressed:
+			log.LogError("Regressed: %s", formatComparison(c))
+			ok = false
+		case c.P <= Alpha && c.Delta != 0:
+			log.LogInfo("Changed: %s", formatComparison(c))
+		}
+	}
+	return ok
+}
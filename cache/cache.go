@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Key accumulates everything an action's result depends on.
+type Key struct {
+	parts []string
+}
+
+func (k *Key) Add(label string, value string) {
+	k.parts = append(k.parts, fmt.Sprintf("%s %d %s", label, len(value), value))
+}
+
+// Add the contents of a file, by path.  Missing files are recorded as such.
+func (k *Key) AddFile(path string) error {
+	hash, err := hashFile(path)
+	if os.IsNotExist(err) {
+		k.Add("missing", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	k.Add("file", path+" "+hash)
+	return nil
+}
+
+func (k *Key) Sum() string {
+	h := sha256.New()
+	for _, part := range k.parts {
+		io.WriteString(h, part)
+		io.WriteString(h, "\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type File struct {
+	Path string
+	Hash string
+	Mode os.FileMode
+}
+
+// Entry is the recorded result of an action.
+type Entry struct {
+	Key         string
+	Description string
+	OK          bool
+	Stdout      []byte
+	Stderr      []byte
+	Files       []File
+	Created     time.Time
+}
+
+// Cache stores entries and the files they produced in a directory, evicting
+// the least recently used entries once it grows past MaxBytes.
+type Cache struct {
+	Dir      string
+	MaxBytes int64
+}
+
+func (c *Cache) entryPath(key string) string {
+	return filepath.Join(c.Dir, "actions", key+".json")
+}
+
+func (c *Cache) blobPath(hash string) string {
+	return filepath.Join(c.Dir, "blobs", hash)
+}
+
+func (c *Cache) Get(key string) (*Entry, bool) {
+	path := c.entryPath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	entry := &Entry{}
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, false
+	}
+	for _, file := range entry.Files {
+		_, err := os.Stat(c.blobPath(file.Hash))
+		if err != nil {
+			return nil, false
+		}
+	}
+	// Mark as recently used.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return entry, true
+}
+
+// Write to a temporary file beside dst and rename it into place, so readers
+// never see half a file and concurrent writers do not collide.
+func writeAtomic(dst string, mode os.FileMode, write func(w io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0777)
+	if err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	err = out.Chmod(mode)
+	if err == nil {
+		err = write(out)
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeAtomic(dst, mode, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// Store an entry along with the files at the given paths.
+func (c *Cache) Put(entry *Entry, paths []string) error {
+	entry.Files = nil
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		blob := c.blobPath(hash)
+		_, err = os.Stat(blob)
+		if os.IsNotExist(err) {
+			err = copyFile(path, blob, 0666)
+		}
+		if err != nil {
+			return err
+		}
+		entry.Files = append(entry.Files, File{Path: path, Hash: hash, Mode: info.Mode().Perm()})
+	}
+	entry.Created = time.Now()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = writeAtomic(c.entryPath(entry.Key), 0666, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return c.Evict()
+}
+
+// Put the entry's files back where they were produced.
+func (c *Cache) Restore(entry *Entry) error {
+	for _, file := range entry.Files {
+		hash, err := hashFile(file.Path)
+		if err == nil && hash == file.Hash {
+			continue
+		}
+		err = copyFile(c.blobPath(file.Hash), file.Path, file.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries, most recently used first.
+func (c *Cache) Entries() ([]*Entry, error) {
+	infos, err := os.ReadDir(filepath.Join(c.Dir, "actions"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	type used struct {
+		entry *Entry
+		time  time.Time
+	}
+	all := []used{}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(c.Dir, "actions", name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		entry := &Entry{}
+		if json.Unmarshal(data, entry) != nil {
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		all = append(all, used{entry: entry, time: stat.ModTime()})
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].time.After(all[j].time)
+	})
+	entries := make([]*Entry, len(all))
+	for i, u := range all {
+		entries[i] = u.entry
+	}
+	return entries, nil
+}
+
+func dirSize(dir string) int64 {
+	size := int64(0)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func (c *Cache) Size() int64 {
+	return dirSize(c.Dir)
+}
+
+// Drop least recently used entries until the cache fits in MaxBytes, then
+// delete files no entry refers to.
+func (c *Cache) Evict() error {
+	if c.MaxBytes <= 0 || c.Size() <= c.MaxBytes {
+		return nil
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		return err
+	}
+	for len(entries) > 0 && c.Size() > c.MaxBytes {
+		last := entries[len(entries)-1]
+		entries = entries[:len(entries)-1]
+		os.Remove(c.entryPath(last.Key))
+		c.collectGarbage(entries)
+	}
+	return nil
+}
+
+func (c *Cache) collectGarbage(entries []*Entry) {
+	live := map[string]bool{}
+	for _, entry := range entries {
+		for _, file := range entry.Files {
+			live[file.Hash] = true
+		}
+	}
+	blobs, err := os.ReadDir(filepath.Join(c.Dir, "blobs"))
+	if err != nil {
+		return
+	}
+	for _, blob := range blobs {
+		// Skip blobs still being written.
+		if !live[blob.Name()] && !strings.HasSuffix(blob.Name(), ".tmp") {
+			os.Remove(c.blobPath(blob.Name()))
+		}
+	}
+}
+
+func (c *Cache) Clear() error {
+	err := os.RemoveAll(filepath.Join(c.Dir, "actions"))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(c.Dir, "blobs"))
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, data string) {
+	err := os.WriteFile(path, []byte(data), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input")
+	writeFile(t, path, "a")
+
+	key := func() string {
+		k := &Key{}
+		k.Add("args", "go vet")
+		err := k.AddFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.Sum()
+	}
+
+	a := key()
+	if a != key() {
+		t.Fatal("key not stable")
+	}
+	writeFile(t, path, "b")
+	b := key()
+	if a == b {
+		t.Fatal("key ignores file contents")
+	}
+	os.Remove(path)
+	if key() == b {
+		t.Fatal("key ignores missing file")
+	}
+}
+
+func TestPutGetRestore(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: filepath.Join(dir, "cache")}
+	output := filepath.Join(dir, "output")
+	writeFile(t, output, "built")
+
+	_, ok := c.Get("k")
+	if ok {
+		t.Fatal("empty cache hit")
+	}
+
+	err := c.Put(&Entry{Key: "k", OK: true, Stdout: []byte("hello")}, []string{output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(output)
+
+	entry, ok := c.Get("k")
+	if !ok {
+		t.Fatal("cache miss")
+	}
+	if !entry.OK || string(entry.Stdout) != "hello" || len(entry.Files) != 1 {
+		t.Fatal(entry)
+	}
+	err = c.Restore(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil || string(data) != "built" {
+		t.Fatal(string(data), err)
+	}
+
+	err = c.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok = c.Get("k")
+	if ok {
+		t.Fatal("hit after clear")
+	}
+}
+
+func TestConcurrentPut(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: filepath.Join(dir, "cache")}
+	output := filepath.Join(dir, "output")
+	writeFile(t, output, strings.Repeat("built", 1<<16))
+
+	// Two runs producing the same output race to store the same blob.
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			errs <- c.Put(&Entry{Key: fmt.Sprint("k", i%2), OK: true}, []string{output})
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.Remove(output)
+	entry, ok := c.Get("k0")
+	if !ok {
+		t.Fatal("cache miss")
+	}
+	err := c.Restore(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil || string(data) != strings.Repeat("built", 1<<16) {
+		t.Fatal(len(data), err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(c.Dir, "*", "*.tmp"))
+	if len(leftovers) != 0 {
+		t.Fatal(leftovers)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: filepath.Join(dir, "cache")}
+	output := filepath.Join(dir, "output")
+
+	keys := []string{"a", "b", "c"}
+	base := time.Now().Add(-time.Hour)
+	for i, key := range keys {
+		writeFile(t, output, key+" is a reasonably sized output")
+		err := c.Put(&Entry{Key: key}, []string{output})
+		if err != nil {
+			t.Fatal(err)
+		}
+		used := base.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(c.entryPath(key), used, used)
+	}
+	// Touch the oldest entry so it is no longer least recently used.
+	_, ok := c.Get("a")
+	if !ok {
+		t.Fatal("miss")
+	}
+
+	c.MaxBytes = c.Size() - 1
+	err := c.Evict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size() > c.MaxBytes {
+		t.Fatal(c.Size(), c.MaxBytes)
+	}
+	_, ok = c.Get("a")
+	if !ok {
+		t.Fatal("recently used entry evicted")
+	}
+	_, ok = c.Get("b")
+	if ok {
+		t.Fatal("least recently used entry kept")
+	}
+}
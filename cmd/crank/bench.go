@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/bench"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Manage the benchmark baseline: "baseline" accepts the latest results,
+// "show" compares them against the current baseline.
+func benchCommand(args []string) {
+	dir := ""
+	actions := []string{}
+
+	app := cmdline.MakeApp("crank bench")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "dir",
+			Value: cmdline.String.Set(&dir),
+		},
+	})
+	app.ExcessArguments(&cmdline.Argument{
+		Name: "show|baseline",
+		Value: cmdline.String.Call(func(value string) {
+			actions = append(actions, value)
+		}),
+	})
+	app.Run(args)
+
+	if dir == "" {
+		workspaceDir, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir = filepath.Join(defaultHistoryDir(workspaceDir), "bench")
+	}
+	store := &bench.Store{Dir: dir}
+
+	latest, err := store.LatestRun()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if latest == nil {
+		log.Fatalf("no benchmark results in %s", dir)
+	}
+
+	if len(actions) == 0 {
+		actions = []string{"show"}
+	}
+	for _, action := range actions {
+		switch action {
+		case "show":
+			baseline, err := store.Baseline()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if baseline == nil {
+				log.Fatal("no baseline")
+			}
+			fmt.Printf("%s compared to baseline %s\n", latest.ID, baseline.ID)
+			for _, c := range bench.Compare(bench.MakeSet(baseline.Results), bench.MakeSet(latest.Results), defaultBenchThreshold) {
+				flag := ""
+				switch {
+				case c.Regressed:
+					flag = " REGRESSED"
+				case c.P > bench.Alpha:
+					flag = " ~"
+				}
+				fmt.Printf("%-40s %-9s %12.4g %12.4g %+7.1f%%%s\n", c.Name, c.Unit, c.Old, c.New, c.Delta*100, flag)
+			}
+		case "baseline":
+			err := store.SaveBaseline(latest)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("Baseline is now", latest.ID)
+		default:
+			log.Fatalf("unknown bench action %#v", action)
+		}
+	}
+}
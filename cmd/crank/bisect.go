@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/git"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/task"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Checks out commits in a worktree inside a scratch GOPATH and runs one
+// task against them.
+type bisector struct {
+	// The package, as found in the scratch GOPATH.
+	pkg        string
+	gopath     string
+	worktree   string
+	configPath string
+	taskName   string
+	// Logs of the failing tasks, by commit.
+	logs map[string][]byte
+}
+
+// A commit the task cannot run at, such as one with a broken crank.json.
+// It is skipped, like "git bisect skip".
+type skipError struct {
+	err error
+}
+
+func (e skipError) Error() string {
+	return e.err.Error()
+}
+
+// Does the task, and everything it depends on, pass at this commit?
+func (b *bisector) test(sha string) (bool, error) {
+	err := git.Checkout(b.worktree, sha)
+	if err != nil {
+		return false, err
+	}
+	packageDir := filepath.Join(b.gopath, "src", b.pkg)
+	config, err := loadConfig(b.configPath, packageDir)
+	if err != nil {
+		return false, skipError{err}
+	}
+	// Past runs are not part of the current history, and nobody is watching.
+	// Workers have their own checkout, not this commit.
+	config.NoHistory = true
+	config.Git = nil
+	config.Workers = nil
+
+	runner, err := createWorkGraph(b.gopath, packageDir, filepath.Join(b.pkg, "..."), config, &task.NullLog{}, []string{b.taskName})
+	if err != nil {
+		return false, skipError{err}
+	}
+	ok := runOnce(runner)
+	if !ok {
+		var transcript bytes.Buffer
+		for _, wrapper := range runner.FileManager.Tasks {
+			if wrapper.ran && wrapper.record.Result == history.Error {
+				transcript.Write(wrapper.transcript.Bytes())
+			}
+		}
+		b.logs[sha] = transcript.Bytes()
+	}
+	return ok, nil
+}
+
+// The untested index strictly between lo and hi closest to their middle,
+// or -1 if there is none.
+func middle(lo int, hi int, skipped map[int]bool) int {
+	mid := lo + (hi-lo)/2
+	for d := 0; mid-d > lo || mid+d < hi; d++ {
+		for _, i := range []int{mid - d, mid + d} {
+			if i > lo && i < hi && !skipped[i] {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Binary search for the first of n commits that fails, given that the last
+// one does and that the one before the first passes.  Commits that cannot
+// be tested are searched around, and may leave several candidates for the
+// first bad commit, oldest first.
+func firstFailing(n int, test func(i int) (bool, error)) ([]int, error) {
+	// i passes for i <= lo and fails for i >= hi.
+	lo, hi := -1, n-1
+	skipped := map[int]bool{}
+	for {
+		mid := middle(lo, hi, skipped)
+		if mid < 0 {
+			break
+		}
+		ok, err := test(mid)
+		if _, skip := err.(skipError); skip {
+			skipped[mid] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	candidates := []int{}
+	for i := lo + 1; i <= hi; i++ {
+		if skipped[i] || i == hi {
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates, nil
+}
+
+// Find the first commit after good, up to bad, where the task fails.
+//
+// Only the first-parent history of bad is searched, as a binary search over
+// commits needs them in a line.  A merge that brings in a failure from its
+// other parent is reported as the first bad commit.
+func (b *bisector) bisect(repo string, good string, bad string) (string, error) {
+	commits, err := git.AncestryPath(repo, good, bad)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("%s is not in the first-parent history of %s", good, bad)
+	}
+
+	check := func(i int) (bool, error) {
+		fmt.Printf("Testing %s, %d of %d\n", git.Short(commits[i]), i+1, len(commits))
+		ok, err := b.test(commits[i])
+		if _, skip := err.(skipError); skip {
+			fmt.Println("  skipped:", err)
+			return ok, err
+		}
+		switch {
+		case err != nil:
+		case ok:
+			fmt.Println("  ok")
+		default:
+			fmt.Println("  FAIL")
+		}
+		return ok, err
+	}
+
+	// Make sure bad really is bad, which also gets its log.
+	ok, err := check(len(commits) - 1)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return "", fmt.Errorf("%s passes, it is not bad", git.Short(bad))
+	}
+
+	candidates, err := firstFailing(len(commits), check)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) > 1 {
+		shas := []string{}
+		for _, i := range candidates {
+			shas = append(shas, git.Short(commits[i]))
+		}
+		return "", fmt.Errorf("commits that could not be tested hide the first bad commit, which is one of %s", strings.Join(shas, " "))
+	}
+	return commits[candidates[0]], nil
+}
+
+// The workspace and package of a directory inside one of the GOPATH
+// workspaces.
+func packageAt(dir string, gopath []string) (string, string, bool) {
+	for _, workspace := range gopath {
+		rel, err := filepath.Rel(filepath.Join(workspace, "src"), dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return workspace, filepath.ToSlash(rel), true
+	}
+	return "", "", false
+}
+
+func bisectCommand(args []string) {
+	var pkg string
+	var configPath string
+	var good string
+	var bad string
+	var taskName string
+
+	app := cmdline.MakeApp("crank bisect")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+		{
+			Long:  "package",
+			Value: (&cmdline.FilePath{Root: "src", MustExist: true}).Set(&pkg),
+		},
+	})
+	app.RequiredArgs([]*cmdline.Argument{
+		{
+			Name:  "good",
+			Value: cmdline.String.Set(&good),
+		},
+		{
+			Name:  "bad",
+			Value: cmdline.String.Set(&bad),
+		},
+		{
+			Name:  "task",
+			Value: cmdline.String.Set(&taskName),
+		},
+	})
+	app.Run(args)
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pkg == "" {
+		// Run from inside the package, like git bisect.
+		var ok bool
+		workspaceDir, pkg, ok = packageAt(workspaceDir, filepath.SplitList(build.Default.GOPATH))
+		if !ok {
+			log.Fatal("not in a package on the GOPATH, use --package from the workspace")
+		}
+	}
+	repo, repoPath, err := repoInWorkspace(workspaceDir, pkg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if configPath != "" {
+		configPath, err = filepath.Abs(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	goodSHA, err := git.ResolveRef(repo, good)
+	if err != nil {
+		log.Fatal(err)
+	}
+	badSHA, err := git.ResolveRef(repo, bad)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	gopath, err := os.MkdirTemp("", "crank-bisect")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	worktree := filepath.Join(gopath, "src", repoPath)
+	err = git.AddWorktree(repo, worktree, badSHA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer git.RemoveWorktree(repo, worktree)
+
+	// The commit under test comes first, anything else it imports is found
+	// in the real workspace.  Cached results are keyed on files relative to
+	// the working directory, so that has to be the scratch GOPATH too.
+	os.Setenv("GOPATH", gopath+string(filepath.ListSeparator)+workspaceDir)
+	err = os.Chdir(gopath)
+	if err != nil {
+		git.RemoveWorktree(repo, worktree)
+		os.RemoveAll(gopath)
+		log.Fatal(err)
+	}
+	// Windows cannot remove the working directory.
+	defer os.Chdir(workspaceDir)
+
+	b := &bisector{
+		pkg:        pkg,
+		gopath:     gopath,
+		worktree:   worktree,
+		configPath: configPath,
+		taskName:   taskName,
+		logs:       map[string][]byte{},
+	}
+	first, err := b.bisect(repo, goodSHA, badSHA)
+	if err != nil {
+		// Deferred cleanup does not run after log.Fatal.
+		os.Chdir(workspaceDir)
+		git.RemoveWorktree(repo, worktree)
+		os.RemoveAll(gopath)
+		log.Fatal(err)
+	}
+	subject, _ := git.Subject(repo, first)
+	fmt.Println()
+	fmt.Printf("First bad commit: %s %s\n", first, subject)
+	fmt.Println()
+	os.Stdout.Write(b.logs[first])
+}
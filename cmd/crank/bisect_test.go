@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"github.com/ncbray/crank/git"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFirstFailing(t *testing.T) {
+	for _, tc := range []struct {
+		// p passes, f fails, s cannot be tested.
+		commits  string
+		expected []int
+	}{
+		{"f", []int{0}},
+		{"pf", []int{1}},
+		{"ppppfff", []int{4}},
+		{"ffff", []int{0}},
+		{"pspff", []int{3}},
+		{"psspspppff", []int{8}},
+		{"ppsff", []int{2, 3}},
+		{"sssf", []int{0, 1, 2, 3}},
+	} {
+		tested := map[int]bool{}
+		candidates, err := firstFailing(len(tc.commits), func(i int) (bool, error) {
+			if tested[i] {
+				t.Fatal(tc.commits, "tested twice", i)
+			}
+			tested[i] = true
+			switch tc.commits[i] {
+			case 's':
+				return false, skipError{errors.New("no task named test")}
+			case 'p':
+				return true, nil
+			}
+			return false, nil
+		})
+		if err != nil {
+			t.Fatal(tc.commits, err)
+		}
+		if len(candidates) != len(tc.expected) {
+			t.Fatal(tc.commits, candidates)
+		}
+		for i := range candidates {
+			if candidates[i] != tc.expected[i] {
+				t.Fatal(tc.commits, candidates)
+			}
+		}
+	}
+
+	// Other errors stop the search.
+	_, err := firstFailing(3, func(i int) (bool, error) {
+		return false, errors.New("checkout failed")
+	})
+	if err == nil {
+		t.Fatal("error ignored")
+	}
+}
+
+func TestPackageAt(t *testing.T) {
+	a := filepath.Join("/", "a")
+	b := filepath.Join("/", "b")
+	gopath := []string{a, b}
+	workspace, pkg, ok := packageAt(filepath.Join(b, "src", "example.com", "pkg"), gopath)
+	if !ok || workspace != b || pkg != "example.com/pkg" {
+		t.Fatal(workspace, pkg, ok)
+	}
+	for _, dir := range []string{b, filepath.Join(b, "src"), filepath.Join("/", "c", "src", "pkg")} {
+		_, _, ok := packageAt(dir, gopath)
+		if ok {
+			t.Fatal(dir)
+		}
+	}
+}
+
+func TestBisect(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	workspace, repo, run := makeRepo(t)
+	write := func(file string, text string) {
+		err := os.WriteFile(filepath.Join(repo, "pkg", file), []byte(text), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit := func(message string) string {
+		run("add", "-A")
+		run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", message)
+		sha, err := git.Head(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	write("a.go", "package pkg\n\nfunc F() int { return 1 }\n")
+	write("a_test.go", "package pkg\n\nimport \"testing\"\n\nfunc TestF(t *testing.T) {\n\tif F() != 1 {\n\t\tt.Fatal(F())\n\t}\n}\n")
+	good := commit("good")
+	write("b.go", "package pkg\n")
+	commit("passes")
+	write("crank.json", "{")
+	commit("cannot be tested")
+	write("crank.json", "{}")
+	commit("passes again")
+	write("a.go", "package pkg\n\nfunc F() int { return 2 }\n")
+	first := commit("breaks F")
+	write("b.go", "package pkg\n\n// Still broken.\n")
+	bad := commit("still broken")
+
+	gopath := t.TempDir()
+	worktree := filepath.Join(gopath, "src", "example.com", "repo")
+	err := git.AddWorktree(repo, worktree, bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer git.RemoveWorktree(repo, worktree)
+	t.Setenv("GO111MODULE", "off")
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOPATH", gopath+string(filepath.ListSeparator)+workspace)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(gopath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bisector{
+		pkg:      "example.com/repo/pkg",
+		gopath:   gopath,
+		worktree: worktree,
+		taskName: "test",
+		logs:     map[string][]byte{},
+	}
+	found, err := b.bisect(repo, good, bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != first {
+		t.Fatal(git.Short(found), git.Short(first))
+	}
+	if len(b.logs[first]) == 0 {
+		t.Fatal("no log for the first bad commit")
+	}
+}
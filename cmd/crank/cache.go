@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/cache"
+	"github.com/ncbray/crank/remote"
+	"github.com/ncbray/crank/task"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultCacheMaxMB = 1024
+
+func defaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "crank"), nil
+}
+
+// What a task does, for the cache key.  Only tasks whose result depends on
+// nothing but their inputs can be described.
+func describeTask(t task.TaskDecl) (string, bool) {
+	command := taskCommand(t)
+	if command == nil {
+		return "", false
+	}
+	if command.Dir != "" {
+		return fmt.Sprintf("command %q env %q dir %q", command.Args, command.Env, command.Dir), true
+	}
+	return fmt.Sprintf("command %q env %q", command.Args, command.Env), true
+}
+
+// The command a task runs, for the cache key.
+func taskCommand(t task.TaskDecl) *task.CommandTask {
+	switch t := t.(type) {
+	case *task.CommandTask:
+		return t
+	case *task.RetryTask:
+		return taskCommand(t.Task)
+	case *remote.Task:
+		return t.Command
+	}
+	return nil
+}
+
+// Inherited environment variables that change what commands do.  PATH
+// decides which tools run.
+var keyEnvPrefixes = []string{"GO", "CGO_", "CC=", "CXX=", "PATH="}
+
+func keyEnv() []string {
+	env := []string{}
+	for _, entry := range os.Environ() {
+		for _, prefix := range keyEnvPrefixes {
+			if strings.HasPrefix(entry, prefix) {
+				env = append(env, entry)
+				break
+			}
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// Add what a command depends on besides its inputs: the workspace, the
+// inherited environment, and the tool itself.  For go, "go env" covers the
+// toolchain version and settings from go env -w.  If set, workspace stands
+// in for the working directory, which is a copy of it.
+func addToolchain(key *cache.Key, command *task.CommandTask, workspace string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if workspace == "" {
+		workspace = dir
+	}
+	standIn := func(text string) string {
+		return strings.Replace(text, dir, workspace, -1)
+	}
+	key.Add("workspace", workspace)
+	key.Add("inherited env", standIn(strings.Join(keyEnv(), "\n")))
+	path, err := exec.LookPath(command.Args[0])
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	key.Add("tool", fmt.Sprintf("%s %d %d", path, info.Size(), info.ModTime().UnixNano()))
+	if filepath.Base(command.Args[0]) == "go" {
+		cmd := exec.Command(path, "env")
+		cmd.Env = append(os.Environ(), command.Env...)
+		out, err := cmd.Output()
+		if err != nil {
+			return err
+		}
+		lines := []string{}
+		for _, line := range strings.Split(string(out), "\n") {
+			// Names a fresh temporary directory on every call.
+			if !strings.Contains(line, "GOGCCFLAGS=") {
+				lines = append(lines, line)
+			}
+		}
+		key.Add("go env", standIn(strings.Join(lines, "\n")))
+	}
+	return nil
+}
+
+// Go commands whose result depends on the packages they build.
+var goPackageCommands = map[string]bool{"build": true, "install": true, "run": true, "test": true, "vet": true}
+
+// Files a go command reads beyond the task's own inputs: everything in the
+// directories of the packages it depends on, their testdata, and the module
+// files.  The standard library is covered by the toolchain.
+func goDependencyFiles(command *task.CommandTask) ([]string, error) {
+	args := command.Args
+	if filepath.Base(args[0]) != "go" || len(args) < 3 || !goPackageCommands[args[1]] {
+		return nil, nil
+	}
+	list := []string{"list", "-deps", "-test", "-f", "{{if not .Standard}}{{.Dir}}\t{{with .Module}}{{.GoMod}}{{end}}{{end}}"}
+	for _, arg := range args[2:] {
+		// Tags change which files are built, other flags are for the command.
+		if strings.HasPrefix(arg, "-tags=") || !strings.HasPrefix(arg, "-") {
+			list = append(list, arg)
+		}
+	}
+	cmd := exec.Command(args[0], list...)
+	cmd.Dir = command.Dir
+	cmd.Env = append(os.Environ(), command.Env...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %s", err)
+	}
+
+	files := []string{}
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	dirs := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		// Test variants of a package share its directory.
+		if len(parts) != 2 || dirs[parts[0]] {
+			continue
+		}
+		dir, goMod := parts[0], parts[1]
+		dirs[dir] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				add(filepath.Join(dir, entry.Name()))
+			}
+		}
+		err = filepath.Walk(filepath.Join(dir, "testdata"), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if goMod != "" {
+			add(goMod)
+			add(filepath.Join(filepath.Dir(goMod), "go.sum"))
+		}
+	}
+	return files, nil
+}
+
+// Workspace relative paths of the files the task reads.
+func (w *TaskWrapper) inputFiles() ([]string, error) {
+	files := []string{}
+	for _, root := range w.InputRoots {
+		err := filepath.Walk(root.Path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if info.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root.Rel, abs)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if w.Match.Match(rel) && !w.IsOutput(rel) {
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (w *TaskWrapper) cacheKey() (string, string, bool) {
+	description, ok := describeTask(w.Task)
+	if !ok {
+		return "", "", false
+	}
+	key := &cache.Key{}
+	key.Add("task", description)
+	err := addToolchain(key, taskCommand(w.Task), w.CacheWorkspace)
+	if err != nil {
+		w.Log.LogError("Not caching: %s", err)
+		return "", "", false
+	}
+	files, err := w.inputFiles()
+	if err != nil {
+		w.Log.LogError("Not caching: %s", err)
+		return "", "", false
+	}
+	deps, err := goDependencyFiles(taskCommand(w.Task))
+	if err != nil {
+		w.Log.LogError("Not caching: %s", err)
+		return "", "", false
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		w.Log.LogError("Not caching: %s", err)
+		return "", "", false
+	}
+	inputs := map[string]bool{}
+	for _, file := range files {
+		inputs[file] = true
+	}
+	for _, dep := range deps {
+		// Workspace relative where possible, like the other inputs.
+		file := filepath.ToSlash(dep)
+		if rel, err := filepath.Rel(wd, dep); err == nil && !strings.HasPrefix(rel, "..") {
+			file = filepath.ToSlash(rel)
+		}
+		if !inputs[file] && !w.IsOutput(file) {
+			inputs[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		err := key.AddFile(file)
+		if err != nil {
+			w.Log.LogError("Not caching: %s", err)
+			return "", "", false
+		}
+	}
+	return key.Sum(), description, true
+}
+
+// Run the task, or replay its result from the cache.
+func (w *TaskWrapper) runTask() bool {
+	if w.Cache == nil {
+		return w.Task.Run(w.Log)
+	}
+	key, description, ok := w.cacheKey()
+	if !ok {
+		return w.Task.Run(w.Log)
+	}
+
+	entry, hit := w.Cache.Get(key)
+	if hit {
+		err := w.Cache.Restore(entry)
+		if err == nil {
+			w.cached = true
+			w.Log.LogInfo("Cached: %s", description)
+			stdout, stderr := w.Log.BeginCapture()
+			if len(entry.Stdout) > 0 {
+				stdout.Write(entry.Stdout)
+			}
+			if len(entry.Stderr) > 0 {
+				stderr.Write(entry.Stderr)
+			}
+			w.Log.EndCapture()
+			return entry.OK
+		}
+		w.Log.LogError("Could not restore cached outputs: %s", err)
+	}
+
+	capture := &task.CaptureLog{}
+	outcome := task.Execute(w.Task, task.MakeMultiLog(w.Log, capture))
+	ok = outcome == task.Passed
+	if outcome == task.Interrupted {
+		// Running again may well give a different answer.
+		return false
+	}
+	err := w.Cache.Put(&cache.Entry{
+		Key:         key,
+		Description: description,
+		OK:          ok,
+		Stdout:      capture.Stdout.Bytes(),
+		Stderr:      capture.Stderr.Bytes(),
+	}, w.outputFiles())
+	if err != nil {
+		w.Log.LogError("Could not cache result: %s", err)
+	}
+	return ok
+}
+
+func cacheCommand(args []string) {
+	dir := ""
+	configPath := ""
+	pkg := ""
+	actions := []string{}
+
+	app := cmdline.MakeApp("crank cache")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "dir",
+			Value: cmdline.String.Set(&dir),
+		},
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+		{
+			Long:  "package",
+			Value: cmdline.String.Set(&pkg),
+		},
+	})
+	app.ExcessArguments(&cmdline.Argument{
+		Name: "list|clear",
+		Value: cmdline.String.Call(func(value string) {
+			actions = append(actions, value)
+		}),
+	})
+	app.Run(args)
+
+	c := &cache.Cache{Dir: dir}
+	if dir == "" {
+		workspaceDir, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+		config, err := commandConfig(workspaceDir, configPath, pkg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c, err = config.ActionCache()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir = c.Dir
+	}
+
+	if len(actions) == 0 {
+		actions = []string{"list"}
+	}
+	for _, action := range actions {
+		switch action {
+		case "list":
+			entries, err := c.Entries()
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, entry := range entries {
+				result := "ok"
+				if !entry.OK {
+					result = "FAIL"
+				}
+				files := []string{}
+				for _, file := range entry.Files {
+					files = append(files, file.Path)
+				}
+				fmt.Printf("%s %-4s %s %s\n", entry.Key[:12], result, entry.Created.Format("2006-01-02 15:04"), entry.Description)
+				if len(files) > 0 {
+					fmt.Printf("    outputs: %s\n", strings.Join(files, " "))
+				}
+			}
+			fmt.Printf("%d entries, %.1f MB in %s\n", len(entries), float64(c.Size())/(1<<20), dir)
+		case "clear":
+			err := c.Clear()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("Cleared", dir)
+		default:
+			log.Fatalf("unknown cache action %#v", action)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/ncbray/crank/cache"
+	"github.com/ncbray/crank/task"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func cachedWrapper(c *cache.Cache, args ...string) *TaskWrapper {
+	return &TaskWrapper{
+		Name:  "test",
+		Task:  task.Command(args...),
+		Log:   &task.NullLog{},
+		Match: &CascadingPathMatch{},
+		Cache: c,
+	}
+}
+
+func TestCacheSkipsInterrupted(t *testing.T) {
+	c := &cache.Cache{Dir: t.TempDir()}
+	if cachedWrapper(c, "no-such-command-crank").runTask() {
+		t.Fatal("missing command passed")
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal(entries)
+	}
+
+	// A command that ran and failed is a result worth keeping.
+	if cachedWrapper(c, "false").runTask() {
+		t.Fatal("false passed")
+	}
+	entries, err = c.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].OK {
+		t.Fatal(entries)
+	}
+}
+
+func TestCacheKeyEnv(t *testing.T) {
+	w := cachedWrapper(nil, "true")
+	t.Setenv("GOFLAGS", "")
+	before, _, ok := w.cacheKey()
+	if !ok {
+		t.Fatal("not cacheable")
+	}
+	t.Setenv("GOFLAGS", "-race")
+	after, _, ok := w.cacheKey()
+	if !ok {
+		t.Fatal("not cacheable")
+	}
+	if before == after {
+		t.Fatal("inherited GOFLAGS not in the key")
+	}
+
+	// Other variables do not matter.
+	t.Setenv("CRANK_UNRELATED", "x")
+	unrelated, _, _ := w.cacheKey()
+	if unrelated != after {
+		t.Fatal("unrelated variable in the key")
+	}
+}
+
+func TestCacheKeyDependencies(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	dir := t.TempDir()
+	write := func(file string, text string) {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(text), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/m\n\ngo 1.20\n")
+	write("a/a.go", "package a\n\nimport _ \"example.com/m/b\"\n")
+	write("b/b.go", "package b\n")
+	write("b/testdata/in.txt", "in")
+	write("c/c.go", "package c\n")
+
+	w := cachedWrapper(nil, "go", "vet", "./a")
+	w.Task.(*task.CommandTask).Dir = dir
+	key := func() string {
+		sum, _, ok := w.cacheKey()
+		if !ok {
+			t.Fatal("not cacheable")
+		}
+		return sum
+	}
+	before := key()
+	if key() != before {
+		t.Fatal("key not stable")
+	}
+	for _, tc := range []struct {
+		file    string
+		text    string
+		changes bool
+	}{
+		{"b/b.go", "package b\n\nconst B = 1\n", true},
+		{"b/testdata/in.txt", "edited", true},
+		{"go.mod", "module example.com/m\n\ngo 1.21\n", true},
+		// Not imported by a.
+		{"c/c.go", "package c\n\nconst C = 1\n", false},
+	} {
+		write(tc.file, tc.text)
+		after := key()
+		if (after != before) != tc.changes {
+			t.Fatal(tc.file, tc.changes)
+		}
+		before = after
+	}
+}
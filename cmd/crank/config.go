@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/ncbray/crank/bench"
+	"github.com/ncbray/crank/cache"
+	"github.com/ncbray/crank/coverage"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/stats"
+	"github.com/ncbray/crank/task"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Duration is a time.Duration written as a string, such as "1.5s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(text)
+	return err
+}
+
+type WatchConfig struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+}
+
+type RetryConfig struct {
+	Attempts int      `json:"attempts"`
+	Backoff  Duration `json:"backoff"`
+}
+
+type TaskConfig struct {
+	// Extra paths that invalidate the task, relative to the config file.
+	Watch []*WatchConfig `json:"watch"`
+	// Rerun a failing task, and call it flaky if a later attempt passes.
+	Retry *RetryConfig `json:"retry"`
+	// Globs for the files the task writes, relative to the config file.
+	// With a matrix, {KEY} stands for the entry's value of KEY, and each
+	// entry needs outputs of its own.
+	Outputs []string `json:"outputs"`
+	// Delete the outputs before rerunning the task.
+	Clean bool `json:"clean"`
+	// Reuse results from the action cache when the inputs have been seen before.
+	Cache bool `json:"cache"`
+	// Run the task once for every combination of these values, such as
+	// {"GOOS": ["linux", "windows"], "GOARCH": ["amd64", "arm64"]}.  Keys
+	// are set in the environment, except "tags" which is passed as -tags.
+	Matrix map[string][]string `json:"matrix"`
+	// For gofmt, rewrite changed files rather than only complaining.
+	Fix bool `json:"fix"`
+	// For lint, the linter to run on the packages, by default staticcheck.
+	Command []string `json:"command"`
+}
+
+const defaultBenchThreshold = 0.1
+
+type BenchConfig struct {
+	// Which benchmarks to run, by default all of them.
+	Pattern string `json:"pattern"`
+	Count   int    `json:"count"`
+	// How much worse a benchmark may get before the node fails, such as 0.1
+	// for 10%.
+	Threshold float64 `json:"threshold"`
+	// Defaults to bench in the history directory.
+	Dir string `json:"dir"`
+	// How many runs to keep, 100 by default and -1 for all of them.
+	MaxRuns int `json:"max_runs"`
+}
+
+type CoverageConfig struct {
+	// Fail the test node when total coverage is below this percentage.
+	Min float64 `json:"min"`
+	// Count coverage of every watched package from every test, not just
+	// each package's own tests.
+	CoverPkg bool `json:"coverpkg"`
+	// Serve the coverage report on this address, such as "localhost:7879".
+	Serve string `json:"serve"`
+	// Defaults to coverage in the history directory.
+	Dir string `json:"dir"`
+}
+
+type GitConfig struct {
+	// Only act on files that differ from this ref, such as "origin/main".
+	DiffBase string `json:"diff_base"`
+}
+
+// Config is read from a JSON file, by default crank.json in the package
+// being watched.  Tasks are keyed by name: "vet", "test", and "install".
+// There is also "build", which only compiles, and the checks "gofmt",
+// "tidy" and "lint".  These only run if configured.
+type Config struct {
+	Tasks map[string]*TaskConfig `json:"tasks"`
+	// Defaults to crank in the user's cache directory.
+	CacheDir   string `json:"cache_dir"`
+	CacheMaxMB int64  `json:"cache_max_mb"`
+	// Run commands on "crank worker" processes at these addresses, such as
+	// "tcp:buildbox:7878" or "unix:/tmp/crank.sock".
+	Workers []string `json:"workers"`
+	// Shared secret for the workers, by default $CRANK_WORKER_TOKEN.
+	WorkerToken string `json:"worker_token"`
+	// Runs are recorded here, by default .crank in the workspace.
+	HistoryDir string `json:"history_dir"`
+	// Do not record runs at all.
+	NoHistory bool `json:"no_history"`
+	// How much history to keep, by default 500 runs going back 30 days.
+	HistoryMaxRuns int      `json:"history_max_runs"`
+	HistoryMaxAge  Duration `json:"history_max_age"`
+	// Warn when a task takes this many times longer than its median.
+	SlowFactor float64 `json:"slow_factor"`
+	// Run benchmarks after the tests, if set.
+	Bench *BenchConfig `json:"bench"`
+	// Collect coverage when testing, if set.
+	Coverage *CoverageConfig `json:"coverage"`
+	// Rerun everything when HEAD moves and label runs with the commit, if
+	// set.
+	Git *GitConfig `json:"git"`
+	Dir string     `json:"-"`
+	// Set when running in a copy of the workspace, so cached results carry
+	// over between copies.
+	CacheWorkspace string `json:"-"`
+}
+
+func (c *Config) SlowCheck() stats.SlowCheck {
+	check := stats.DefaultSlowCheck
+	if c.SlowFactor > 0 {
+		check.Factor = c.SlowFactor
+	}
+	return check
+}
+
+func (c *Config) BenchTask(workspaceDir string, subpath string) *bench.Task {
+	bc := c.Bench
+	t := &bench.Task{
+		Package:   subpath,
+		Pattern:   bc.Pattern,
+		Count:     bc.Count,
+		Threshold: bc.Threshold,
+		Store:     &bench.Store{Dir: filepath.Join(defaultHistoryDir(workspaceDir), "bench"), MaxRuns: bc.MaxRuns},
+	}
+	if t.Pattern == "" {
+		t.Pattern = "."
+	}
+	if t.Count == 0 {
+		t.Count = 5
+	}
+	if t.Threshold == 0 {
+		t.Threshold = defaultBenchThreshold
+	}
+	if bc.Dir != "" {
+		t.Store.Dir = c.Path(bc.Dir)
+	} else if c.HistoryDir != "" {
+		t.Store.Dir = filepath.Join(c.Path(c.HistoryDir), "bench")
+	}
+	return t
+}
+
+// The test task, with coverage if it is enabled.
+func (c *Config) TestTask(workspaceDir string, subpath string) task.TaskDecl {
+	if c.Coverage == nil {
+		return task.Command("go", "test", subpath)
+	}
+	return c.CoverageTask(workspaceDir, subpath)
+}
+
+func (c *Config) CoverageTask(workspaceDir string, subpath string) *coverage.Task {
+	cc := c.Coverage
+	t := &coverage.Task{
+		Test: task.Command("go", "test", subpath),
+		Dir:  filepath.Join(defaultHistoryDir(workspaceDir), "coverage"),
+		Min:  cc.Min,
+	}
+	if cc.CoverPkg {
+		t.CoverPkg = subpath
+	}
+	if cc.Dir != "" {
+		t.Dir = c.Path(cc.Dir)
+	} else if c.HistoryDir != "" {
+		t.Dir = filepath.Join(c.Path(c.HistoryDir), "coverage")
+	}
+	return t
+}
+
+func (c *Config) HistoryStore(workspaceDir string) *history.Store {
+	if c.NoHistory {
+		return nil
+	}
+	store := &history.Store{
+		Dir:     defaultHistoryDir(workspaceDir),
+		MaxRuns: c.HistoryMaxRuns,
+		MaxAge:  c.HistoryMaxAge.Duration,
+	}
+	if c.HistoryDir != "" {
+		store.Dir = c.Path(c.HistoryDir)
+	}
+	return store
+}
+
+func (c *Config) CacheEnabled() bool {
+	for _, tc := range c.Tasks {
+		if tc.Cache {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) ActionCache() (*cache.Cache, error) {
+	dir := c.CacheDir
+	if dir == "" {
+		var err error
+		dir, err = defaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dir = c.Path(dir)
+	}
+	maxMB := c.CacheMaxMB
+	if maxMB == 0 {
+		maxMB = defaultCacheMaxMB
+	}
+	return &cache.Cache{Dir: dir, MaxBytes: maxMB << 20}, nil
+}
+
+func (c *Config) Task(name string) *TaskConfig {
+	tc, ok := c.Tasks[name]
+	if !ok {
+		return &TaskConfig{}
+	}
+	return tc
+}
+
+// Resolve a path in the config relative to the config file.
+func (c *Config) Path(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.Dir, path)
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, err
+	}
+	config.Dir = filepath.Dir(path)
+	return config, nil
+}
+
+// An empty config is used when no crank.json exists.
+func DefaultConfig(dir string) *Config {
+	return &Config{Dir: dir}
+}
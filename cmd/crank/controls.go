@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/workgraph"
+	"io"
+	"os"
+	"strings"
+)
+
+const controlHelp = "Keys: r rerun failures, a rerun all, p pause, f show failures, q quit, 1-9 toggle targets"
+
+// Turn key presses into functions for the watch loop to call.
+func readKeys(control chan<- func(), runner *IncrementalTaskRunner, quit func()) {
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		key := buf[0]
+		control <- func() {
+			runner.HandleKey(key, quit)
+		}
+	}
+}
+
+func (runner *IncrementalTaskRunner) HandleKey(key byte, quit func()) {
+	switch {
+	case key == 'r':
+		runner.rerunFailed()
+	case key == 'a':
+		for _, task := range runner.FileManager.Tasks {
+			runner.Graph.Invalidate(task.Node)
+		}
+		runner.trigger = append(runner.trigger, "rerun all")
+		runner.runUnlessPaused()
+	case key == 'p':
+		runner.paused = !runner.paused
+		if runner.paused {
+			fmt.Println("Paused, changes will run when resumed")
+		} else {
+			fmt.Println("Resumed")
+			runner.Run()
+		}
+	case key == 'f':
+		runner.showFailures(os.Stdout)
+	case key == 'q':
+		fmt.Println("Quitting")
+		quit()
+	case key >= '1' && key <= '9':
+		runner.toggleTarget(int(key - '1'))
+	case key == '\n' || key == '\r' || key == ' ':
+	default:
+		fmt.Println(controlHelp)
+	}
+}
+
+func (runner *IncrementalTaskRunner) rerunFailed() {
+	failed := false
+	for _, task := range runner.FileManager.Tasks {
+		if task.Node.State() == workgraph.ERROR {
+			runner.Graph.Invalidate(task.Node)
+			failed = true
+		}
+	}
+	if !failed {
+		fmt.Println("Nothing failed")
+		return
+	}
+	runner.trigger = append(runner.trigger, "rerun failures")
+	runner.runUnlessPaused()
+}
+
+// Invalidated tasks wait for the runner to be resumed.
+func (runner *IncrementalTaskRunner) runUnlessPaused() {
+	if runner.paused {
+		fmt.Println("Paused, will run when resumed")
+		return
+	}
+	runner.Run()
+}
+
+func (runner *IncrementalTaskRunner) showFailures(w io.Writer) {
+	shown := false
+	for _, task := range runner.FileManager.Tasks {
+		if task.Node.State() == workgraph.ERROR && task.record.Result == history.Error {
+			w.Write(task.transcript.Bytes())
+			shown = true
+		}
+	}
+	if !shown {
+		fmt.Fprintln(w, "No failures")
+	}
+}
+
+// Make a task a target or stop it being one.  Dependencies follow along.
+func (runner *IncrementalTaskRunner) toggleTarget(index int) {
+	tasks := runner.FileManager.Tasks
+	if index >= len(tasks) {
+		return
+	}
+	node := tasks[index].Node
+	if node.Target() {
+		runner.Graph.UnmarkLive(node)
+	} else {
+		runner.Graph.MarkLive(node)
+	}
+	runner.printTargets(os.Stdout)
+	if node.Target() {
+		runner.runUnlessPaused()
+	}
+}
+
+// Number the tasks for toggling, with a * on the targets.
+func (runner *IncrementalTaskRunner) printTargets(w io.Writer) {
+	parts := []string{}
+	for i, task := range runner.FileManager.Tasks {
+		if i >= 9 {
+			break
+		}
+		mark := ""
+		if task.Node.Target() {
+			mark = "*"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s%s", i+1, mark, task.Name))
+	}
+	fmt.Fprintln(w, "Targets:", strings.Join(parts, "  "))
+}
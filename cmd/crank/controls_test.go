@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/ncbray/crank/task"
+	"github.com/ncbray/crank/workgraph"
+	"strings"
+	"testing"
+)
+
+// Tasks build, test, and lint, with test depending on build and test and
+// lint as targets.  A task passes while its entry in pass is true.
+func controlRunner(pass map[string]bool) (*IncrementalTaskRunner, map[string]int) {
+	runs := map[string]int{}
+	g := &workgraph.WorkGraph{}
+	tasks := []*TaskWrapper{}
+	for _, name := range []string{"build", "test", "lint"} {
+		name := name
+		wrapper := &TaskWrapper{
+			Name: name,
+			Task: task.Func(name, func(ctx context.Context, log task.TaskLog) error {
+				runs[name] += 1
+				if !pass[name] {
+					return errors.New("broken")
+				}
+				return nil
+			}),
+		}
+		wrapper.Log = task.MakeTextLog(&wrapper.transcript).CreateSubtask(name)
+		wrapper.Node = g.CreateNode(wrapper)
+		tasks = append(tasks, wrapper)
+	}
+	g.CreateEdge(tasks[0].Node, tasks[1].Node, false)
+	g.MarkLive(tasks[1].Node)
+	g.MarkLive(tasks[2].Node)
+	runner := &IncrementalTaskRunner{
+		FileManager: &FileManager{Graph: g, Tasks: tasks},
+		Graph:       g,
+	}
+	runner.Run()
+	return runner, runs
+}
+
+func TestHandleKey(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		paused   bool
+		testPass bool
+		keys     string
+		runs     map[string]int
+		targets  string
+	}{
+		{name: "rerun all", keys: "a", runs: map[string]int{"build": 2, "test": 2, "lint": 2}},
+		{name: "rerun all paused", paused: true, keys: "a", runs: map[string]int{"build": 1, "test": 1, "lint": 1}},
+		{name: "rerun all resumed", paused: true, keys: "ap", runs: map[string]int{"build": 2, "test": 2, "lint": 2}},
+		{name: "rerun failures", keys: "r", runs: map[string]int{"build": 1, "test": 2, "lint": 1}},
+		{name: "rerun failures paused", paused: true, keys: "r", runs: map[string]int{"build": 1, "test": 1, "lint": 1}},
+		{name: "rerun failures resumed", paused: true, keys: "rp", runs: map[string]int{"build": 1, "test": 2, "lint": 1}},
+		{name: "nothing failed", testPass: true, keys: "r", runs: map[string]int{"build": 1, "test": 1, "lint": 1}},
+		{name: "untarget", keys: "3a", runs: map[string]int{"build": 2, "test": 2, "lint": 1}, targets: "1 build  2 *test  3 lint"},
+		{name: "target dependency", keys: "1", runs: map[string]int{"build": 1, "test": 1, "lint": 1}, targets: "1 *build  2 *test  3 *lint"},
+		{name: "untarget all", keys: "23a", runs: map[string]int{"build": 1, "test": 1, "lint": 1}, targets: "1 build  2 test  3 lint"},
+		{name: "retarget", keys: "33", runs: map[string]int{"build": 1, "test": 1, "lint": 1}, targets: "1 build  2 *test  3 *lint"},
+		{name: "out of range", keys: "9", runs: map[string]int{"build": 1, "test": 1, "lint": 1}},
+		{name: "other keys", keys: "x \n", runs: map[string]int{"build": 1, "test": 1, "lint": 1}},
+	} {
+		runner, runs := controlRunner(map[string]bool{"build": true, "test": tc.testPass, "lint": true})
+		runner.paused = tc.paused
+		quit := false
+		for _, key := range []byte(tc.keys) {
+			runner.HandleKey(key, func() { quit = true })
+		}
+		if quit {
+			t.Fatal(tc.name, "quit")
+		}
+		for name, expected := range tc.runs {
+			if runs[name] != expected {
+				t.Fatal(tc.name, runs)
+			}
+		}
+		if tc.targets != "" {
+			var out bytes.Buffer
+			runner.printTargets(&out)
+			if out.String() != "Targets: "+tc.targets+"\n" {
+				t.Fatal(tc.name, out.String())
+			}
+		}
+	}
+}
+
+func TestHandleKeyPauseAndQuit(t *testing.T) {
+	runner, _ := controlRunner(map[string]bool{"build": true, "test": true, "lint": true})
+	runner.HandleKey('p', nil)
+	if !runner.paused {
+		t.Fatal("not paused")
+	}
+	runner.HandleKey('p', nil)
+	if runner.paused {
+		t.Fatal("not resumed")
+	}
+	quit := false
+	runner.HandleKey('q', func() { quit = true })
+	if !quit {
+		t.Fatal("did not quit")
+	}
+}
+
+func TestShowFailures(t *testing.T) {
+	pass := map[string]bool{"build": true, "test": false, "lint": true}
+	runner, _ := controlRunner(pass)
+	var out bytes.Buffer
+	runner.showFailures(&out)
+	if !strings.Contains(out.String(), "test failed: broken") || strings.Contains(out.String(), "lint") {
+		t.Fatal(out.String())
+	}
+
+	pass["test"] = true
+	runner.rerunFailed()
+	out.Reset()
+	runner.showFailures(&out)
+	if out.String() != "No failures\n" {
+		t.Fatal(out.String())
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/crank/git"
+	"github.com/ncbray/crank/watch"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Follows the commit checked out in the package's repository.
+type gitTracker struct {
+	dir string
+	// Workspace relative path of the .git directory.
+	gitDir string
+	head   string
+	branch string
+	// In diff mode, only files that differ from this ref are considered.
+	diffBase string
+	// Workspace relative path of dir, to turn git's paths into ours.
+	prefix    string
+	changed   map[string]bool
+	refreshed time.Time
+	stale     bool
+}
+
+// How often to ask git which files differ, when events keep coming.
+const refreshInterval = 200 * time.Millisecond
+
+func newGitTracker(workspaceDir string, packageDir string, gc *GitConfig) (*gitTracker, []watch.Root, error) {
+	dir, err := filepath.Abs(packageDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	gitDir, err := git.GitDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	gitDirRel, err := filepath.Rel(workspaceDir, gitDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix, err := filepath.Rel(workspaceDir, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := &gitTracker{
+		dir:      dir,
+		gitDir:   filepath.ToSlash(gitDirRel),
+		diffBase: gc.DiffBase,
+		prefix:   filepath.ToSlash(prefix),
+		stale:    true,
+	}
+	if g.diffBase != "" {
+		_, err = git.ResolveRef(dir, g.diffBase)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	_, _, err = g.update()
+	if err != nil {
+		return nil, nil, err
+	}
+	// HEAD and the index live directly in the git directory, branches under
+	// refs.
+	roots := []watch.Root{
+		{Path: gitDir, Rel: workspaceDir},
+		{Path: filepath.Join(gitDir, "refs"), Recursive: true, Rel: workspaceDir},
+	}
+	return g, roots, nil
+}
+
+func (g *gitTracker) IsGitPath(path string) bool {
+	return path == g.gitDir || strings.HasPrefix(path, g.gitDir+"/")
+}
+
+// Reread HEAD, returning whether it moved and whether the branch changed.
+func (g *gitTracker) update() (bool, bool, error) {
+	head, err := git.Head(g.dir)
+	if err != nil {
+		return false, false, err
+	}
+	branch, err := git.Branch(g.dir)
+	if err != nil {
+		return false, false, err
+	}
+	moved := head != g.head
+	switched := branch != g.branch
+	g.head = head
+	g.branch = branch
+	return moved || switched, switched, nil
+}
+
+func (g *gitTracker) Label() string {
+	if g.branch == "" {
+		return git.Short(g.head)
+	}
+	return g.branch + "@" + git.Short(g.head)
+}
+
+// Should a change to this file be acted on?  Outside of diff mode, always.
+func (g *gitTracker) Considered(path string) bool {
+	if g.diffBase == "" {
+		return true
+	}
+	if g.changed[path] {
+		return true
+	}
+	// The file may have only just started to differ.  Do not ask git about
+	// every event, since a checkout can produce thousands.
+	if g.stale || time.Since(g.refreshed) > refreshInterval {
+		g.stale = false
+		g.refreshed = time.Now()
+		files, err := git.ChangedFiles(g.dir, g.diffBase)
+		if err != nil {
+			fmt.Println("git:", err)
+			return true
+		}
+		g.changed = map[string]bool{}
+		for _, file := range files {
+			g.changed[g.prefix+"/"+file] = true
+		}
+	}
+	return g.changed[path]
+}
+
+// Handle an event in the git directory.  Returns true if everything needs
+// to run again.
+func (runner *IncrementalTaskRunner) gitChanged() bool {
+	g := runner.Git
+	g.stale = true
+	moved, switched, err := g.update()
+	if err != nil {
+		fmt.Println("git:", err)
+		return false
+	}
+	if !moved {
+		return false
+	}
+	if switched {
+		fmt.Println("switched to", g.Label())
+	} else {
+		fmt.Println("HEAD is now", g.Label())
+	}
+	runner.headMoved = true
+	runner.trigger = append(runner.trigger, "HEAD "+g.Label())
+	for _, task := range runner.FileManager.Tasks {
+		runner.Graph.Invalidate(task.Node)
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/git"
+	"github.com/ncbray/crank/history"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func defaultHistoryDir(workspaceDir string) string {
+	return filepath.Join(workspaceDir, ".crank")
+}
+
+// The store the watch records to, given --config or --package.
+func configHistoryStore(configPath string, pkg string) *history.Store {
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := commandConfig(workspaceDir, configPath, pkg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store := config.HistoryStore(workspaceDir)
+	if store == nil {
+		log.Fatal("history is turned off by no_history")
+	}
+	return store
+}
+
+// Append the tasks that ran to the history, along with their logs.
+func (runner *IncrementalTaskRunner) recordRun(start time.Time, end time.Time) error {
+	trigger := runner.trigger
+	runner.trigger = nil
+	if runner.History == nil {
+		return nil
+	}
+	run := &history.RunRecord{
+		ID:      history.MakeID(start),
+		Start:   start,
+		End:     end,
+		Trigger: trigger,
+	}
+	if runner.Git != nil {
+		run.Commit = runner.Git.head
+		run.Branch = runner.Git.branch
+	}
+	for _, task := range runner.FileManager.Tasks {
+		if !task.ran {
+			continue
+		}
+		record := task.record
+		path, err := runner.History.WriteLog(run.ID, task.Name, task.transcript.Bytes())
+		if err != nil {
+			return err
+		}
+		record.Log = path
+		run.Nodes = append(run.Nodes, &record)
+	}
+	if len(run.Nodes) == 0 {
+		return nil
+	}
+	return runner.History.Append(run)
+}
+
+func resultText(result string) string {
+	switch result {
+	case history.Success:
+		return "ok"
+	case history.Error:
+		return "FAIL"
+	default:
+		return result
+	}
+}
+
+func printRun(run *history.RunRecord) {
+	nodes := []string{}
+	for _, node := range run.Nodes {
+		nodes = append(nodes, fmt.Sprintf("%s:%s", node.Task, resultText(node.Result)))
+	}
+	trigger := "startup"
+	if len(run.Trigger) > 0 {
+		trigger = run.Trigger[0]
+		if len(run.Trigger) > 1 {
+			trigger += fmt.Sprintf(" +%d", len(run.Trigger)-1)
+		}
+	}
+	commit := ""
+	if run.Commit != "" {
+		commit = " " + git.Short(run.Commit)
+	}
+	fmt.Printf("%s%s %8s %s (%s)\n", run.ID, commit, run.End.Sub(run.Start).Round(time.Millisecond), strings.Join(nodes, " "), trigger)
+}
+
+// Print the logs of a run's tasks, by default only those that failed.
+func showRun(store *history.Store, id string, taskName string) {
+	if id == "last" {
+		runs, err := store.Query(&history.Filter{Limit: 1})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(runs) == 0 {
+			log.Fatal("no runs recorded")
+		}
+		id = runs[0].ID
+	}
+	run, err := store.Run(id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printRun(run)
+	for _, node := range run.Nodes {
+		if taskName != "" && node.Task != taskName || taskName == "" && node.Result == history.Success {
+			continue
+		}
+		data, err := store.ReadLog(node)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println()
+		os.Stdout.Write(data)
+	}
+}
+
+func historyCommand(args []string) {
+	dir := ""
+	configPath := ""
+	pkg := ""
+	filter := &history.Filter{Limit: 20}
+	actions := []string{}
+
+	app := cmdline.MakeApp("crank history")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "dir",
+			Value: cmdline.String.Set(&dir),
+		},
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+		{
+			Long:  "package",
+			Value: cmdline.String.Set(&pkg),
+		},
+		{
+			Long:  "task",
+			Value: cmdline.String.Set(&filter.Task),
+		},
+		{
+			Long:  "status",
+			Value: cmdline.String.Set(&filter.Result),
+		},
+		{
+			Long:  "limit",
+			Value: cmdline.Int.Set(&filter.Limit),
+		},
+	})
+	app.ExcessArguments(&cmdline.Argument{
+		Name: "list|show <run> [task]",
+		Value: cmdline.String.Call(func(value string) {
+			actions = append(actions, value)
+		}),
+	})
+	app.Run(args)
+
+	store := &history.Store{Dir: dir}
+	if dir == "" {
+		store = configHistoryStore(configPath, pkg)
+	}
+
+	if filter.Result == "fail" {
+		filter.Result = history.Error
+	}
+
+	if len(actions) == 0 {
+		actions = []string{"list"}
+	}
+	switch actions[0] {
+	case "list":
+		runs, err := store.Query(filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Oldest first, so the newest run ends up next to the prompt.
+		for i := len(runs) - 1; i >= 0; i-- {
+			printRun(runs[i])
+		}
+	case "show":
+		if len(actions) < 2 || len(actions) > 3 {
+			log.Fatal("usage: crank history show <run|last> [task]")
+		}
+		taskName := filter.Task
+		if len(actions) == 3 {
+			taskName = actions[2]
+		}
+		showRun(store, actions[1], taskName)
+	default:
+		log.Fatalf("unknown history action %#v", actions[0])
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/git"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/task"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const hookMarker = "# Installed by crank hook install."
+
+// The git hook, which runs crank from the workspace it was installed from.
+// Before a commit, the staged files are what gets tested.
+func hookScript(name string, crank string, workspaceDir string, configPath string, pkg string) string {
+	args := []string{shellQuote(crank), "hook", "run"}
+	note := "# Tests the staged files, not the working tree."
+	if name == "pre-commit" {
+		args = append(args, "--staged")
+	} else {
+		note = "# Tests the working tree, which may differ from what is pushed."
+	}
+	if configPath != "" {
+		args = append(args, "--config", shellQuote(configPath))
+	}
+	args = append(args, shellQuote(pkg))
+	return fmt.Sprintf("#!/bin/sh\n%s\n%s\ncd %s && exec %s\n", hookMarker, note, shellQuote(workspaceDir), strings.Join(args, " "))
+}
+
+// Where the repository holding pkg sits, and its path within the GOPATH.
+func repoInWorkspace(workspaceDir string, pkg string) (string, string, error) {
+	repo, err := git.TopLevel(filepath.Join(workspaceDir, "src", pkg))
+	if err != nil {
+		return "", "", err
+	}
+	repoPath, err := filepath.Rel(filepath.Join(workspaceDir, "src"), repo)
+	if err != nil {
+		return "", "", err
+	}
+	return repo, repoPath, nil
+}
+
+// Copy the staged files of the repository holding pkg into a scratch
+// GOPATH, and return it.
+func stagedSnapshot(workspaceDir string, pkg string) (string, error) {
+	_, repoPath, err := repoInWorkspace(workspaceDir, pkg)
+	if err != nil {
+		return "", err
+	}
+	gopath, err := os.MkdirTemp("", "crank-hook")
+	if err != nil {
+		return "", err
+	}
+	err = git.CheckoutIndex(filepath.Join(workspaceDir, "src", pkg), filepath.Join(gopath, "src", repoPath))
+	if err != nil {
+		os.RemoveAll(gopath)
+		return "", err
+	}
+	return gopath, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func installHook(hooksDir string, name string, script string, force bool) error {
+	path := filepath.Join(hooksDir, name)
+	existing, err := os.ReadFile(path)
+	if err == nil && !force && !strings.Contains(string(existing), hookMarker) {
+		return fmt.Errorf("%s already exists, use --force to replace it", path)
+	}
+	err = os.MkdirAll(hooksDir, 0777)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(script), 0777)
+}
+
+// Run the live targets once instead of watching.  Returns false if any of
+// them failed or could not run.
+func runOnce(runner *IncrementalTaskRunner) bool {
+	runner.Run()
+	live := runner.Graph.LiveNodes
+	return live.Error == 0 && live.Waiting == 0 && live.Pending == 0
+}
+
+// Print how each task went, and the logs of those that failed.
+func reportFailures(runner *IncrementalTaskRunner) {
+	for _, task := range runner.FileManager.Tasks {
+		if !task.ran {
+			fmt.Printf("%-8s %s\n", "blocked", task.Name)
+			continue
+		}
+		fmt.Printf("%-8s %s %s\n", resultText(task.record.Result), task.Name, roundDuration(task.record.Duration))
+	}
+	for _, task := range runner.FileManager.Tasks {
+		if task.ran && task.record.Result == history.Error {
+			fmt.Println()
+			os.Stdout.Write(task.transcript.Bytes())
+		}
+	}
+}
+
+func hookRun(args []string) {
+	var pkg string
+	var configPath string
+	staged := false
+
+	app := cmdline.MakeApp("crank hook run")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+		{
+			Long:  "staged",
+			Value: cmdline.Bool.Set(&staged),
+		},
+	})
+	app.RequiredArgs([]*cmdline.Argument{
+		{
+			Name:  "package",
+			Value: (&cmdline.FilePath{Root: "src", MustExist: true}).Set(&pkg),
+		},
+	})
+	app.Run(args)
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if configPath != "" {
+		configPath, err = filepath.Abs(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	ok, err := checkHook(workspaceDir, pkg, configPath, staged)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// Run the checks once, in a copy of the staged files if staged is set.
+func checkHook(workspaceDir string, pkg string, configPath string, staged bool) (bool, error) {
+	realWorkspace := workspaceDir
+	if staged {
+		// Test a copy of what is about to be committed.  Anything else it
+		// imports is found in the real workspace.
+		gopath, err := stagedSnapshot(workspaceDir, pkg)
+		if err != nil {
+			return false, err
+		}
+		defer os.RemoveAll(gopath)
+		os.Setenv("GOPATH", gopath+string(filepath.ListSeparator)+workspaceDir)
+		err = os.Chdir(gopath)
+		if err != nil {
+			return false, err
+		}
+		// Windows cannot remove the working directory.
+		defer os.Chdir(workspaceDir)
+		workspaceDir = gopath
+	}
+	packageDir := filepath.Join("src", pkg)
+	config, err := loadConfig(configPath, packageDir)
+	if err != nil {
+		return false, err
+	}
+	// Workers test their own checkout, not this one.
+	config.Workers = nil
+	if staged {
+		// The snapshot is thrown away, and its history with it.
+		config.NoHistory = true
+		config.CacheWorkspace = realWorkspace
+	}
+
+	runner, err := createWorkGraph(workspaceDir, packageDir, filepath.Join(pkg, "..."), config, &task.NullLog{}, nil)
+	if err != nil {
+		return false, err
+	}
+	ok := runOnce(runner)
+	reportFailures(runner)
+	return ok, nil
+}
+
+func hookInstall(args []string) {
+	var pkg string
+	var configPath string
+	hooks := []string{}
+	force := false
+
+	app := cmdline.MakeApp("crank hook install")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+		{
+			// pre-commit or pre-push, may be repeated.
+			Long: "hook",
+			Value: cmdline.String.Call(func(value string) {
+				hooks = append(hooks, value)
+			}),
+		},
+		{
+			Long:  "force",
+			Value: cmdline.Bool.Set(&force),
+		},
+	})
+	app.RequiredArgs([]*cmdline.Argument{
+		{
+			Name:  "package",
+			Value: (&cmdline.FilePath{Root: "src", MustExist: true}).Set(&pkg),
+		},
+	})
+	app.Run(args)
+
+	if len(hooks) == 0 {
+		hooks = []string{"pre-commit"}
+	}
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	crank, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if configPath != "" {
+		configPath, err = filepath.Abs(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	hooksDir, err := git.HooksDir(filepath.Join("src", pkg))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, name := range hooks {
+		if name != "pre-commit" && name != "pre-push" {
+			log.Fatalf("unsupported hook %#v", name)
+		}
+		err = installHook(hooksDir, name, hookScript(name, crank, workspaceDir, configPath, pkg), force)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Installed", filepath.Join(hooksDir, name))
+	}
+}
+
+func hookCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: crank hook install|run ...")
+	}
+	switch args[0] {
+	case "install":
+		hookInstall(args[1:])
+	case "run":
+		hookRun(args[1:])
+	default:
+		log.Fatalf("unknown hook action %#v", args[0])
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/ncbray/crank/cache"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHookScript(t *testing.T) {
+	script := hookScript("pre-commit", "/bin/crank", "/work space", "", "example.com/pkg")
+	if !strings.Contains(script, "hook run --staged 'example.com/pkg'") || !strings.Contains(script, "cd '/work space'") {
+		t.Fatal(script)
+	}
+	script = hookScript("pre-push", "/bin/crank", "/work", "/work/crank.json", "example.com/pkg")
+	if strings.Contains(script, "--staged") || !strings.Contains(script, "--config '/work/crank.json'") {
+		t.Fatal(script)
+	}
+	if !strings.Contains(script, "working tree") {
+		t.Fatal("limitation not stated", script)
+	}
+}
+
+// A workspace holding the repository example.com/repo, and a function to
+// run git in it.
+func makeRepo(t *testing.T) (string, string, func(args ...string)) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	workspace := t.TempDir()
+	repo := filepath.Join(workspace, "src", "example.com", "repo")
+	err := os.MkdirAll(filepath.Join(repo, "pkg"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatal(string(out), err)
+		}
+	}
+	git("init", "-q")
+	return workspace, repo, git
+}
+
+func TestStagedSnapshot(t *testing.T) {
+	workspace, repo, git := makeRepo(t)
+	file := filepath.Join(repo, "pkg", "a.go")
+	os.WriteFile(file, []byte("package pkg // staged\n"), 0666)
+	git("add", "pkg/a.go")
+	os.WriteFile(file, []byte("package pkg // unstaged\n"), 0666)
+
+	gopath, err := stagedSnapshot(workspace, "example.com/repo/pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	data, err := os.ReadFile(filepath.Join(gopath, "src", "example.com", "repo", "pkg", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package pkg // staged\n" {
+		t.Fatal(string(data))
+	}
+}
+
+func TestStagedHookCacheHit(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	workspace, repo, git := makeRepo(t)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	for file, text := range map[string]string{
+		"pkg/a.go":       "package pkg\n",
+		"pkg/a_test.go":  "package pkg\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+		"pkg/crank.json": `{"cache_dir": "` + filepath.ToSlash(cacheDir) + `", "no_history": true, "tasks": {"test": {"cache": true}}}`,
+	} {
+		err := os.WriteFile(filepath.Join(repo, filepath.FromSlash(file)), []byte(text), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("add", "pkg")
+
+	t.Setenv("GO111MODULE", "off")
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOPATH", workspace)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each run tests a fresh snapshot of the same index.
+	for i := 0; i < 2; i++ {
+		ok, err := checkHook(workspace, "example.com/repo/pkg", "", true)
+		if err != nil || !ok {
+			t.Fatal(i, ok, err)
+		}
+		entries, err := (&cache.Cache{Dir: cacheDir}).Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatal(i, "cache missed", len(entries))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ncbray/crank/task"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const defaultLinter = "staticcheck"
+
+// The Go files under dir, skipping the directories the go tool ignores:
+// testdata, vendor, and names starting with "." or "_".
+func goSourceFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path != dir && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "_") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Fails listing the files gofmt would change.
+func gofmtTask(packageDir string) task.TaskDecl {
+	return task.Func("gofmt -l "+packageDir, func(ctx context.Context, log task.TaskLog) error {
+		sources, err := goSourceFiles(packageDir)
+		if err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			return nil
+		}
+		out, err := exec.CommandContext(ctx, "gofmt", append([]string{"-l"}, sources...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("gofmt: %s\n%s", err, out)
+		}
+		files := strings.Fields(string(out))
+		for _, file := range files {
+			log.LogError("Not formatted: %s", file)
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("%d files need gofmt", len(files))
+		}
+		return nil
+	})
+}
+
+// Runs the linter on the packages, unless it is not installed.
+func linterTask(command []string, subpath string) task.TaskDecl {
+	if len(command) == 0 {
+		command = []string{defaultLinter}
+	}
+	linter := &task.CommandTask{Args: append(append([]string{}, command...), subpath)}
+	return task.Func("lint", func(ctx context.Context, log task.TaskLog) error {
+		_, err := exec.LookPath(command[0])
+		if err != nil {
+			log.LogInfo("Skipping, %s is not on the PATH", command[0])
+			return nil
+		}
+		if !linter.Run(log) {
+			return fmt.Errorf("%s found problems", command[0])
+		}
+		return nil
+	})
+}
+
+// Rewrite a changed Go file with gofmt.
+func fixFormatting(path string) {
+	if !strings.HasSuffix(path, ".go") {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	out, err := exec.Command("gofmt", "-w", path).CombinedOutput()
+	if err != nil {
+		fmt.Printf("gofmt -w %s: %s\n%s", path, err, out)
+	}
+}
+
+// Remember a file to format once the changes settle.  A checkout only shows
+// up as HEAD moving after it has written the files, so they cannot be fixed
+// as they arrive.
+func (runner *IncrementalTaskRunner) queueFormatting(path string) {
+	if !runner.FixFormatting || runner.paused || !strings.HasSuffix(path, ".go") {
+		return
+	}
+	if runner.unformatted == nil {
+		runner.unformatted = map[string]bool{}
+	}
+	runner.unformatted[path] = true
+}
+
+// Format the queued files, unless they came from a checkout.
+func (runner *IncrementalTaskRunner) fixQueuedFormatting() {
+	queued := runner.unformatted
+	runner.unformatted = nil
+	if runner.headMoved {
+		return
+	}
+	for path := range queued {
+		before := stampFile(path)
+		fixFormatting(path)
+		after := stampFile(path)
+		if after != before {
+			if runner.formatted == nil {
+				runner.formatted = map[string]fileStamp{}
+			}
+			runner.formatted[path] = after
+		}
+	}
+}
+
+// Is this change just gofmt rewriting the file?
+func (runner *IncrementalTaskRunner) formattedByUs(path string) bool {
+	stamp, ok := runner.formatted[path]
+	if !ok {
+		return false
+	}
+	delete(runner.formatted, path)
+	return stampFile(path) == stamp
+}
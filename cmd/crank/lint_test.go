@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/crank/task"
+	"github.com/ncbray/crank/workgraph"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const unformatted = "package p\nfunc  F() {}\n"
+
+func formattingRunner(t *testing.T) (*IncrementalTaskRunner, string) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt is not installed")
+	}
+	path := filepath.ToSlash(filepath.Join(t.TempDir(), "p.go"))
+	err := os.WriteFile(path, []byte(unformatted), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &workgraph.WorkGraph{}
+	runner := &IncrementalTaskRunner{
+		FileManager:   &FileManager{Graph: g},
+		Graph:         g,
+		FixFormatting: true,
+	}
+	return runner, path
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFixFormatting(t *testing.T) {
+	runner, path := formattingRunner(t)
+	runner.FileChanged(path)
+	runner.Idle()
+	if readFile(t, path) == unformatted {
+		t.Fatal("not formatted")
+	}
+	// The rewrite is not a change of its own.
+	if runner.FileChanged(path) {
+		t.Fatal("rewrite seen as a change")
+	}
+}
+
+func TestNoFixAfterCheckout(t *testing.T) {
+	runner, path := formattingRunner(t)
+	// HEAD moves after the checkout has written the files.
+	runner.FileChanged(path)
+	runner.headMoved = true
+	runner.Idle()
+	if readFile(t, path) != unformatted {
+		t.Fatal("checkout was reformatted")
+	}
+}
+
+func TestNoFixWhilePaused(t *testing.T) {
+	runner, path := formattingRunner(t)
+	runner.paused = true
+	runner.FileChanged(path)
+	runner.Idle()
+	runner.paused = false
+	runner.Idle()
+	if readFile(t, path) != unformatted {
+		t.Fatal("formatted while paused")
+	}
+}
+
+func TestGofmtTask(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt is not installed")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"testdata/t.go", "vendor/v/v.go", "_old/o.go", ".hidden/h.go"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(unformatted), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	formatted := filepath.Join(dir, "p.go")
+	if err := os.WriteFile(formatted, []byte("package p\n\nfunc F() {}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Ignored directories are not checked.
+	if !gofmtTask(dir).Run(&task.NullLog{}) {
+		t.Fatal("ignored directories were checked")
+	}
+
+	if err := os.WriteFile(formatted, []byte(unformatted), 0666); err != nil {
+		t.Fatal(err)
+	}
+	log := &messageLog{}
+	if gofmtTask(dir).Run(log) {
+		t.Fatal("unformatted file passed")
+	}
+	if !strings.Contains(strings.Join(log.messages, "\n"), "Not formatted: "+formatted) {
+		t.Fatal(log.messages)
+	}
+}
+
+// Records the messages logged.
+type messageLog struct {
+	task.NullLog
+	messages []string
+}
+
+func (log *messageLog) LogInfo(format string, args ...interface{}) {
+	log.messages = append(log.messages, fmt.Sprintf(format, args...))
+}
+
+func (log *messageLog) LogError(format string, args ...interface{}) {
+	log.messages = append(log.messages, fmt.Sprintf(format, args...))
+}
+
+func TestLinterTask(t *testing.T) {
+	log := &messageLog{}
+	if !linterTask([]string{"no-such-linter-crank"}, "./...").Run(log) {
+		t.Fatal("missing linter failed")
+	}
+	if !strings.Contains(strings.Join(log.messages, "\n"), "not on the PATH") {
+		t.Fatal(log.messages)
+	}
+
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("false is not installed")
+	}
+	if !linterTask([]string{"true"}, "./...").Run(&task.NullLog{}) {
+		t.Fatal("clean lint failed")
+	}
+	if linterTask([]string{"false"}, "./...").Run(&task.NullLog{}) {
+		t.Fatal("failed lint passed")
+	}
+}
@@ -1,15 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/bmatcuk/doublestar"
 	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/cache"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/remote"
+	"github.com/ncbray/crank/stats"
 	"github.com/ncbray/crank/task"
 	"github.com/ncbray/crank/watch"
 	"github.com/ncbray/crank/workgraph"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type PathMatch struct {
@@ -33,6 +44,14 @@ func (m *CascadingPathMatch) Match(path string) bool {
 	return ok
 }
 
+// Copy the match, appending extra matches that take precedence.
+func (m *CascadingPathMatch) With(matches ...PathMatch) *CascadingPathMatch {
+	combined := make([]PathMatch, 0, len(m.Matches)+len(matches))
+	combined = append(combined, m.Matches...)
+	combined = append(combined, matches...)
+	return &CascadingPathMatch{Matches: combined}
+}
+
 type FileManager struct {
 	Graph *workgraph.WorkGraph
 	Tasks []*TaskWrapper
@@ -40,6 +59,23 @@ type FileManager struct {
 
 func (fm *FileManager) FileChanged(path string) bool {
 	matched := false
+
+	// Outputs only invalidate the task that produces them.  Dependents see
+	// the change through the graph instead.
+	isOutput := false
+	for _, task := range fm.Tasks {
+		if task.IsOutput(path) {
+			isOutput = true
+			if task.OutputChanged(path) {
+				fm.Graph.Invalidate(task.Node)
+				matched = true
+			}
+		}
+	}
+	if isOutput {
+		return matched
+	}
+
 	for _, task := range fm.Tasks {
 		if task.Match.Match(path) {
 			fm.Graph.Invalidate(task.Node)
@@ -50,32 +86,229 @@ func (fm *FileManager) FileChanged(path string) bool {
 }
 
 type TaskWrapper struct {
+	Name  string
 	Task  task.TaskDecl
 	Log   task.TaskLog
 	Node  *workgraph.Node
 	Match *CascadingPathMatch
+	// Where the task's inputs live.
+	InputRoots []watch.Root
+	// If set, results are looked up here before running the task.
+	Cache *cache.Cache
+	// The workspace results are keyed on, when running in a copy of it.
+	CacheWorkspace string
+	// Files the task writes, which are not treated as inputs.
+	Outputs *CascadingPathMatch
+	Clean   bool
+	outputs map[string]fileStamp
+	// The task this was expanded from, for tasks with a matrix.
+	Matrix      string
+	MatrixLabel string
+	// Did the last run only pass after retrying?
+	Flaky      bool
+	Runs       int
+	FlakyCount int
+	// The most recent run, for the history.
+	ran        bool
+	cached     bool
+	record     history.NodeRecord
+	transcript bytes.Buffer
 }
 
 func (w *TaskWrapper) Invalidated() {
 }
 
 func (w *TaskWrapper) Run() bool {
-	return w.Task.Run(w.Log)
+	w.transcript.Reset()
+	start := time.Now()
+	w.Log.Begin(start)
+	if w.Outputs != nil {
+		if w.Clean {
+			w.cleanOutputs()
+		}
+		w.makeOutputDirs()
+	}
+	w.cached = false
+	ok := w.runTask()
+	w.snapshotOutputs()
+	end := time.Now()
+	w.Log.End(end, end.Sub(start))
+
+	w.Runs += 1
+	flaky, canBeFlaky := w.Task.(task.FlakyTask)
+	w.Flaky = ok && canBeFlaky && flaky.Flaky()
+	if w.Flaky {
+		w.FlakyCount += 1
+	}
+
+	w.ran = true
+	w.record = history.NodeRecord{Task: w.Name, Start: start, End: end, Duration: end.Sub(start), Cached: w.cached}
+	switch {
+	case w.Flaky:
+		w.record.Result = history.Flaky
+	case ok:
+		w.record.Result = history.Success
+	default:
+		w.record.Result = history.Error
+	}
+	return ok
 }
 
 type IncrementalTaskRunner struct {
 	FileManager *FileManager
 	Graph       *workgraph.WorkGraph
+	Roots       []watch.Root
+	// How many tasks may run at once.
+	Jobs int
+	// Where runs are recorded, if anywhere.
+	History *history.Store
+	// When to warn that a task has got slower.
+	SlowCheck stats.SlowCheck
+	// Run gofmt -w on changed files.
+	FixFormatting bool
+	unformatted   map[string]bool
+	formatted     map[string]fileStamp
+	// Changes are noted but not run while paused.
+	paused bool
+	// Set when following git.
+	Git       *gitTracker
+	headMoved bool
+	trigger   []string
 }
 
 func (runner *IncrementalTaskRunner) Run() {
-	fmt.Println("Running...")
-	runner.Graph.Run()
+	if runner.Git != nil {
+		fmt.Printf("Running %s...\n", runner.Git.Label())
+	} else {
+		fmt.Println("Running...")
+	}
+	runner.headMoved = false
+	for _, task := range runner.FileManager.Tasks {
+		task.Flaky = false
+		task.ran = false
+	}
+	start := time.Now()
+	runner.Graph.RunParallel(runner.Jobs)
+	end := time.Now()
+	printMatrixSummary(os.Stdout, runner.FileManager.Tasks)
+	for _, task := range runner.FileManager.Tasks {
+		if task.Flaky {
+			fmt.Printf("Flaky: %s (%d of %d runs)\n", task.Name, task.FlakyCount, task.Runs)
+		}
+	}
+	err := runner.reportSlowTasks()
+	if err != nil {
+		fmt.Println("History:", err)
+	}
+	err = runner.recordRun(start, end)
+	if err != nil {
+		fmt.Println("History:", err)
+	}
 	fmt.Println("Done...")
 	fmt.Println()
 }
 
-func createWorkGraph(subpath string, logger task.TaskLog) *IncrementalTaskRunner {
+// The extra roots a task's config asks for, and the task's extended match.
+func extraRoots(workspaceDir string, config *Config, name string, base *CascadingPathMatch) (*CascadingPathMatch, []watch.Root, error) {
+	extra := []PathMatch{}
+	roots := []watch.Root{}
+	for _, wc := range config.Task(name).Watch {
+		path, err := filepath.Abs(config.Path(wc.Path))
+		if err != nil {
+			return nil, nil, err
+		}
+		rel, err := filepath.Rel(workspaceDir, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		glob := filepath.ToSlash(rel)
+		if wc.Recursive {
+			glob += "/**"
+		} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+			// Events are for the files in the directory, not the directory.
+			glob += "/*"
+		}
+		extra = append(extra, PathMatch{Glob: glob})
+		roots = append(roots, watch.Root{Path: path, Recursive: wc.Recursive, Rel: workspaceDir})
+	}
+	return base.With(extra...), roots, nil
+}
+
+func addRoots(roots []watch.Root, extra []watch.Root) []watch.Root {
+	for _, root := range extra {
+		duplicate := false
+		for _, other := range roots {
+			if other == root {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// Workspace relative globs for a task's outputs, and roots to watch them.
+func outputGlobs(workspaceDir string, config *Config, name string, entry *matrixEntry, roots []watch.Root) (*CascadingPathMatch, []watch.Root, error) {
+	tc := config.Task(name)
+	if len(tc.Outputs) == 0 {
+		return nil, roots, nil
+	}
+	matches := []PathMatch{}
+	for _, output := range tc.Outputs {
+		if entry != nil {
+			output = entry.expand(output)
+		}
+		path, err := filepath.Abs(config.Path(output))
+		if err != nil {
+			return nil, nil, err
+		}
+		rel, err := filepath.Rel(workspaceDir, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		glob := filepath.ToSlash(rel)
+		matches = append(matches, PathMatch{Glob: glob})
+
+		// Watch the nearest directory that exists, since the task creates
+		// the output directory when it first runs.
+		base := existingDir(workspaceDir, globBase(glob))
+		if base == "" || base == "." {
+			// Watching the whole workspace is not practical.
+			continue
+		}
+		covered := false
+		for _, root := range roots {
+			if !root.Recursive {
+				continue
+			}
+			rootPath, err := filepath.Abs(root.Path)
+			if err != nil {
+				continue
+			}
+			rootRel, err := filepath.Rel(workspaceDir, rootPath)
+			if err != nil {
+				continue
+			}
+			rootRel = filepath.ToSlash(rootRel)
+			if base == rootRel || strings.HasPrefix(base, rootRel+"/") {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		dir := filepath.Join(workspaceDir, filepath.FromSlash(base))
+		roots = append(roots, watch.Root{Path: dir, Recursive: true, Rel: workspaceDir})
+	}
+	return &CascadingPathMatch{Matches: matches}, roots, nil
+}
+
+// Live names the tasks to run, nil means the usual targets.
+func createWorkGraph(workspaceDir string, packageDir string, subpath string, config *Config, logger task.TaskLog, live []string) (*IncrementalTaskRunner, error) {
 	// TODO be sensitive to directory renames and deletetion.
 	// TODO ignore .git/
 
@@ -92,41 +325,236 @@ func createWorkGraph(subpath string, logger task.TaskLog) *IncrementalTaskRunner
 		},
 	}
 
-	g := &workgraph.WorkGraph{}
+	var err error
+	// Tasks left out of the live list must not run.
+	g := &workgraph.WorkGraph{LiveOnly: live != nil}
 	tasks := []*TaskWrapper{}
+	roots := []watch.Root{
+		{Path: packageDir, Recursive: true, Rel: workspaceDir},
+	}
+
+	var actionCache *cache.Cache
+	if config.CacheEnabled() {
+		actionCache, err = config.ActionCache()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workers, err := dialWorkers(config)
+	if err != nil {
+		return nil, err
+	}
+	jobs := 1
+	if workers != nil {
+		jobs = workers.Size()
+	}
 
-	attach := func(g *workgraph.WorkGraph, wrapper *TaskWrapper) *TaskWrapper {
+	attach := func(g *workgraph.WorkGraph, name string, entry *matrixEntry, wrapper *TaskWrapper) (*TaskWrapper, error) {
+		match, taskRoots, err := extraRoots(workspaceDir, config, name, wrapper.Match)
+		if err != nil {
+			return nil, err
+		}
+		wrapper.Match = match
+		wrapper.InputRoots = append([]watch.Root{roots[0]}, taskRoots...)
+		roots = addRoots(roots, taskRoots)
+		wrapper.Outputs, roots, err = outputGlobs(workspaceDir, config, name, entry, roots)
+		if err != nil {
+			return nil, err
+		}
+		wrapper.Clean = config.Task(name).Clean
+		if config.Task(name).Cache {
+			wrapper.Cache = actionCache
+			wrapper.CacheWorkspace = config.CacheWorkspace
+		}
+		if wrapper.Name == "" {
+			wrapper.Name = name
+		}
+		wrapper.Log = task.MakeMultiLog(
+			logger.CreateSubtask(wrapper.Name),
+			task.MakeTextLog(&wrapper.transcript).CreateSubtask(wrapper.Name),
+		)
+		if command, ok := wrapper.Task.(*task.CommandTask); ok && workers != nil {
+			wrapper.Task = &remote.Task{Pool: workers, Command: command}
+		}
+		retry := config.Task(name).Retry
+		if retry != nil && retry.Attempts > 1 {
+			wrapper.Task = task.Retry(wrapper.Task, retry.Attempts, retry.Backoff.Duration)
+		}
 		wrapper.Node = g.CreateNode(wrapper)
 		tasks = append(tasks, wrapper)
-		return wrapper
+		return wrapper, nil
 	}
 
-	vet := attach(g, &TaskWrapper{
+	// A task with a matrix becomes a node for each combination.
+	expand := func(g *workgraph.WorkGraph, name string, wrapper *TaskWrapper) ([]*TaskWrapper, error) {
+		matrix := config.Task(name).Matrix
+		if len(matrix) == 0 {
+			attached, err := attach(g, name, nil, wrapper)
+			if err != nil {
+				return nil, err
+			}
+			return []*TaskWrapper{attached}, nil
+		}
+		entries := expandMatrix(matrix)
+		err := checkMatrixOutputs(name, config.Task(name).Outputs, entries)
+		if err != nil {
+			return nil, err
+		}
+		expanded := []*TaskWrapper{}
+		for _, entry := range entries {
+			t, err := entry.apply(wrapper.Task)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			attached, err := attach(g, name, entry, &TaskWrapper{
+				Name:        name + "[" + entry.Label() + "]",
+				Task:        t,
+				Match:       wrapper.Match,
+				Matrix:      name,
+				MatrixLabel: entry.Label(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, attached)
+		}
+		return expanded, nil
+	}
+
+	connect := func(srcs []*TaskWrapper, dsts []*TaskWrapper, orderOnly bool) {
+		for _, src := range srcs {
+			for _, dst := range dsts {
+				g.CreateEdge(src.Node, dst.Node, orderOnly)
+			}
+		}
+	}
+
+	vet, err := expand(g, "vet", &TaskWrapper{
 		Task:  task.Command("go", "vet", subpath),
-		Log:   logger,
 		Match: all_go,
 	})
-	test := attach(g, &TaskWrapper{
-		Task:  task.Command("go", "test", subpath),
-		Log:   logger,
+	if err != nil {
+		return nil, err
+	}
+	test, err := expand(g, "test", &TaskWrapper{
+		Task:  config.TestTask(workspaceDir, subpath),
 		Match: all_go,
 	})
-	install := attach(g, &TaskWrapper{
+	if err != nil {
+		return nil, err
+	}
+	install, err := expand(g, "install", &TaskWrapper{
 		Task:  task.Command("go", "install", subpath),
-		Log:   logger,
 		Match: all_go_no_tests,
 	})
-	g.CreateEdge(vet.Node, test.Node, true)
-	g.CreateEdge(test.Node, install.Node, false)
-	g.MarkLive(install.Node)
+	if err != nil {
+		return nil, err
+	}
+	connect(vet, test, true)
+	connect(test, install, false)
+	if len(config.Task("install").Matrix) > 0 {
+		connect(vet, install, true)
+	}
+	targets := install
+
+	// Only compiles, which is what a matrix of platforms usually wants.
+	if _, ok := config.Tasks["build"]; ok {
+		build, err := expand(g, "build", &TaskWrapper{
+			Task:  task.Command("go", "build", subpath),
+			Match: all_go_no_tests,
+		})
+		if err != nil {
+			return nil, err
+		}
+		connect(vet, build, true)
+		targets = append(targets, build...)
+	}
+
+	// Checks that do not hold up the tests, and only run if configured.
+	lint := []struct {
+		name  string
+		task  task.TaskDecl
+		match *CascadingPathMatch
+	}{
+		{"gofmt", gofmtTask(packageDir), all_go},
+		{"tidy", &task.CommandTask{Args: []string{"go", "mod", "tidy", "-diff"}, Dir: packageDir}, all_go.With(
+			PathMatch{Glob: "**/go.mod"},
+			PathMatch{Glob: "**/go.sum"},
+		)},
+		{"lint", linterTask(config.Task("lint").Command, subpath), all_go},
+	}
+	for _, check := range lint {
+		if _, ok := config.Tasks[check.name]; !ok {
+			continue
+		}
+		checks, err := expand(g, check.name, &TaskWrapper{
+			Task:  check.task,
+			Match: check.match,
+		})
+		if err != nil {
+			return nil, err
+		}
+		connect(vet, checks, true)
+		targets = append(targets, checks...)
+	}
+
+	var tracker *gitTracker
+	if config.Git != nil {
+		var gitRoots []watch.Root
+		tracker, gitRoots, err = newGitTracker(workspaceDir, packageDir, config.Git)
+		if err != nil {
+			return nil, err
+		}
+		roots = addRoots(roots, gitRoots)
+	}
+
+	if config.Bench != nil {
+		benchmarks, err := expand(g, "bench", &TaskWrapper{
+			Task:  config.BenchTask(workspaceDir, subpath),
+			Match: all_go,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Benchmarks of code that fails its tests are not interesting, but
+		// a run is still worth it to see the numbers.
+		connect(test, benchmarks, true)
+		targets = append(targets, benchmarks...)
+	}
+
+	if live != nil {
+		targets = []*TaskWrapper{}
+		for _, name := range live {
+			found := false
+			for _, wrapper := range tasks {
+				if wrapper.Name == name || wrapper.Matrix == name {
+					targets = append(targets, wrapper)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("no task named %#v", name)
+			}
+		}
+	}
+	for _, target := range targets {
+		g.MarkLive(target.Node)
+	}
 
 	return &IncrementalTaskRunner{
 		FileManager: &FileManager{
 			Graph: g,
 			Tasks: tasks,
 		},
-		Graph: g,
-	}
+		Graph:         g,
+		Roots:         roots,
+		Jobs:          jobs,
+		History:       config.HistoryStore(workspaceDir),
+		SlowCheck:     config.SlowCheck(),
+		Git:           tracker,
+		FixFormatting: config.Task("gofmt").Fix,
+	}, nil
 }
 
 func (runner *IncrementalTaskRunner) Begin() {
@@ -136,48 +564,181 @@ func (runner *IncrementalTaskRunner) Begin() {
 func (runner *IncrementalTaskRunner) FileChanged(path string) bool {
 	path = filepath.ToSlash(path)
 
+	if runner.Git != nil && runner.Git.IsGitPath(path) {
+		return runner.gitChanged()
+	}
+
 	// Do not watch git files.
 	is_git, _ := doublestar.Match("**/.git/**", path)
 	if is_git {
 		return false
 	}
 
+	// Our own writes to outputs are not worth mentioning.
+	if runner.FileManager.UnchangedOutput(path) {
+		return false
+	}
+
+	if runner.Git != nil && !runner.Git.Considered(path) {
+		return false
+	}
+
+	if runner.formattedByUs(path) {
+		return false
+	}
+
+	// Everything is already going to run, a checkout should not print
+	// every file it touched.
+	if runner.headMoved {
+		return runner.FileManager.FileChanged(path)
+	}
+
+	runner.queueFormatting(path)
+
 	fmt.Println("changed", path)
+	runner.trigger = append(runner.trigger, path)
 	return runner.FileManager.FileChanged(path)
 }
 
 func (runner *IncrementalTaskRunner) Idle() {
+	if runner.paused {
+		return
+	}
+	runner.fixQueuedFormatting()
 	runner.Run()
 }
 
-func doGoWorkflow(workspaceDir string, packageRoot string) {
+// Cancelled when the process is asked to shut down.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
+func loadConfig(configPath string, packageDir string) (*Config, error) {
+	if configPath != "" {
+		return LoadConfig(configPath)
+	}
+	defaultPath := filepath.Join(packageDir, "crank.json")
+	_, err := os.Stat(defaultPath)
+	if os.IsNotExist(err) {
+		return DefaultConfig(packageDir), nil
+	}
+	return LoadConfig(defaultPath)
+}
+
+// The config for commands like "crank cache", from --config or the crank.json
+// of --package.  With neither, the defaults apply.
+func commandConfig(workspaceDir string, configPath string, pkg string) (*Config, error) {
+	if configPath == "" && pkg == "" {
+		return DefaultConfig(workspaceDir), nil
+	}
+	return loadConfig(configPath, filepath.Join(workspaceDir, "src", pkg))
+}
+
+func doGoWorkflow(ctx context.Context, workspaceDir string, packageRoot string, configPath string) {
 	packageDir := filepath.Join("src", packageRoot)
 
 	subpath := filepath.Join(packageRoot, "...")
 
-	err := watch.WatchFiles(
-		filepath.Join(packageDir, "..."),
-		watch.Rel(workspaceDir, createWorkGraph(subpath, task.MakeConsoleLog())),
-	)
+	config, err := loadConfig(configPath, packageDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner, err := createWorkGraph(workspaceDir, packageDir, subpath, config, task.MakeConsoleLog(), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.Coverage != nil && config.Coverage.Serve != "" {
+		handler := config.CoverageTask(workspaceDir, subpath).Handler()
+		go func() {
+			err := http.ListenAndServe(config.Coverage.Serve, handler)
+			if err != nil {
+				fmt.Println("Coverage server:", err)
+			}
+		}()
+		fmt.Printf("Serving coverage on http://%s/\n", config.Coverage.Serve)
+	}
+
+	ctx, quit := context.WithCancel(ctx)
+	defer quit()
+	restore := cbreak()
+	defer restore()
+	control := make(chan func())
+	go readKeys(control, runner, quit)
+	fmt.Println(controlHelp)
+	runner.printTargets(os.Stdout)
+
+	err = watch.WatchRootsControl(ctx, runner.Roots, runner, control)
 	if err != nil {
+		restore()
 		panic(err)
 	}
 }
 
+// Is arg a package in the workspace rather than a subcommand?
+func isPackage(workspaceDir string, arg string) bool {
+	info, err := os.Stat(filepath.Join(workspaceDir, "src", arg))
+	return err == nil && info.IsDir()
+}
+
 func main() {
 	workspace_dir, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// A package in the workspace wins, as it did before there were
+	// subcommands.
+	if len(os.Args) > 1 && !isPackage(workspace_dir, os.Args[1]) {
+		switch os.Args[1] {
+		case "cache":
+			cacheCommand(os.Args[2:])
+			return
+		case "history":
+			historyCommand(os.Args[2:])
+			return
+		case "stats":
+			statsCommand(os.Args[2:])
+			return
+		case "bench":
+			benchCommand(os.Args[2:])
+			return
+		case "hook":
+			hookCommand(os.Args[2:])
+			return
+		case "bisect":
+			bisectCommand(os.Args[2:])
+			return
+		case "worker":
+			workerCommand(os.Args[2:])
+			return
+		}
+	}
+
 	goPkg := &cmdline.FilePath{
 		Root:      "src",
 		MustExist: true,
 	}
 
 	var pkg string
+	var configPath string
 
 	app := cmdline.MakeApp("crank_worker")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+	})
 	app.RequiredArgs([]*cmdline.Argument{
 		{
 			Name:  "package",
@@ -186,5 +747,5 @@ func main() {
 	})
 	app.Run(os.Args[1:])
 
-	doGoWorkflow(workspace_dir, pkg)
+	doGoWorkflow(signalContext(), workspace_dir, pkg, configPath)
 }
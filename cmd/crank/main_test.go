@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Make a workspace with a package and a sibling directory of shared files.
+func makeWorkspace(t *testing.T) (string, *Config) {
+	workspace := t.TempDir()
+	for _, dir := range []string{"src/pkg", "src/shared/proto"} {
+		err := os.MkdirAll(filepath.Join(workspace, dir), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"src/shared/gen.txt", "src/shared/proto/a.proto"} {
+		err := os.WriteFile(filepath.Join(workspace, file), []byte("x"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workspace, DefaultConfig(filepath.Join(workspace, "src/pkg"))
+}
+
+func TestExtraRoots(t *testing.T) {
+	workspace, config := makeWorkspace(t)
+	config.Tasks = map[string]*TaskConfig{
+		"test": {Watch: []*WatchConfig{
+			{Path: "../shared/proto"},
+			{Path: "../shared", Recursive: true},
+			{Path: "../shared/gen.txt"},
+		}},
+	}
+	base := &CascadingPathMatch{}
+	match, roots, err := extraRoots(workspace, config, "test", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"src/shared/proto/*", "src/shared/**", "src/shared/gen.txt"}
+	if len(match.Matches) != len(expected) {
+		t.Fatal(match.Matches)
+	}
+	for i, glob := range expected {
+		if match.Matches[i].Glob != glob {
+			t.Fatal(i, match.Matches[i].Glob, glob)
+		}
+	}
+	if len(base.Matches) != 0 {
+		t.Fatal(base.Matches)
+	}
+
+	if len(roots) != 3 {
+		t.Fatal(roots)
+	}
+	if roots[0].Path != filepath.Join(workspace, "src/shared/proto") || roots[0].Recursive || roots[0].Rel != workspace {
+		t.Fatal(roots[0])
+	}
+	if !roots[1].Recursive {
+		t.Fatal(roots[1])
+	}
+}
+
+func TestExtraRootsMatchEvents(t *testing.T) {
+	workspace, config := makeWorkspace(t)
+	for _, tc := range []struct {
+		watch   WatchConfig
+		file    string
+		matched bool
+	}{
+		{WatchConfig{Path: "../shared/proto"}, "src/shared/proto/a.proto", true},
+		{WatchConfig{Path: "../shared/proto"}, "src/shared/proto/sub/b.proto", false},
+		{WatchConfig{Path: "../shared"}, "src/shared/proto/a.proto", false},
+		{WatchConfig{Path: "../shared", Recursive: true}, "src/shared/proto/a.proto", true},
+		{WatchConfig{Path: "../shared/gen.txt"}, "src/shared/gen.txt", true},
+		{WatchConfig{Path: "../shared/gen.txt"}, "src/shared/other.txt", false},
+	} {
+		watch := tc.watch
+		config.Tasks = map[string]*TaskConfig{"test": {Watch: []*WatchConfig{&watch}}}
+		match, _, err := extraRoots(workspace, config, "test", &CascadingPathMatch{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match.Match(tc.file) != tc.matched {
+			t.Fatal(tc.watch, tc.file, tc.matched)
+		}
+	}
+}
+
+func TestCascadingPathMatch(t *testing.T) {
+	match := &CascadingPathMatch{Matches: []PathMatch{
+		{Glob: "src/pkg/**/*.go"},
+		{Glob: "src/pkg/**/*_test.go", Invert: true},
+	}}
+	if !match.Match("src/pkg/a.go") {
+		t.Fatal("a.go")
+	}
+	if match.Match("src/pkg/a_test.go") {
+		t.Fatal("a_test.go")
+	}
+	with := match.With(PathMatch{Glob: "src/pkg/keep_test.go"})
+	if !with.Match("src/pkg/keep_test.go") || match.Match("src/pkg/keep_test.go") {
+		t.Fatal("keep_test.go")
+	}
+}
+
+func TestCommandConfig(t *testing.T) {
+	workspace, _ := makeWorkspace(t)
+	err := os.WriteFile(filepath.Join(workspace, "src/pkg/crank.json"), []byte(`{"cache_dir": "cache"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := commandConfig(workspace, "", "pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.ActionCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Dir != filepath.Join(workspace, "src/pkg/cache") {
+		t.Fatal(c.Dir)
+	}
+
+	config, err = commandConfig(workspace, filepath.Join(workspace, "src/pkg/crank.json"), "")
+	if err != nil || config.CacheDir != "cache" {
+		t.Fatal(config, err)
+	}
+
+	// Without either, the defaults.
+	config, err = commandConfig(workspace, "", "")
+	if err != nil || config.CacheDir != "" {
+		t.Fatal(config, err)
+	}
+}
+
+func TestIsPackage(t *testing.T) {
+	workspace, _ := makeWorkspace(t)
+	err := os.MkdirAll(filepath.Join(workspace, "src/cache"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPackage(workspace, "cache") || !isPackage(workspace, "pkg") {
+		t.Fatal("package not found")
+	}
+	if isPackage(workspace, "history") || isPackage(workspace, "shared/gen.txt") {
+		t.Fatal("not a package")
+	}
+}
+
+func TestCommandHistoryDir(t *testing.T) {
+	workspace, _ := makeWorkspace(t)
+	err := os.WriteFile(filepath.Join(workspace, "src/pkg/crank.json"), []byte(`{"history_dir": "../../runs"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := commandConfig(workspace, "", "pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := config.HistoryStore(workspace)
+	if store == nil || store.Dir != filepath.Join(workspace, "runs") {
+		t.Fatal(store)
+	}
+	config, err = commandConfig(workspace, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.HistoryStore(workspace).Dir != defaultHistoryDir(workspace) {
+		t.Fatal(config.HistoryStore(workspace).Dir)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/crank/coverage"
+	"github.com/ncbray/crank/task"
+	"io"
+	"sort"
+	"strings"
+)
+
+// One combination of matrix values.
+type matrixEntry struct {
+	keys   []string
+	values map[string]string
+}
+
+// Keys that sort first, in this order, so labels read like "linux/amd64".
+var matrixKeyOrder = map[string]int{"GOOS": 1, "GOARCH": 2, "CGO_ENABLED": 3, "tags": 4}
+
+func sortMatrixKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		oi, oj := matrixKeyOrder[keys[i]], matrixKeyOrder[keys[j]]
+		if oi == 0 {
+			oi = len(matrixKeyOrder) + 1
+		}
+		if oj == 0 {
+			oj = len(matrixKeyOrder) + 1
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// Every combination of the matrix values.
+func expandMatrix(matrix map[string][]string) []*matrixEntry {
+	keys := []string{}
+	for key := range matrix {
+		keys = append(keys, key)
+	}
+	sortMatrixKeys(keys)
+
+	entries := []*matrixEntry{{keys: keys, values: map[string]string{}}}
+	for _, key := range keys {
+		next := []*matrixEntry{}
+		for _, entry := range entries {
+			for _, value := range matrix[key] {
+				values := map[string]string{key: value}
+				for k, v := range entry.values {
+					values[k] = v
+				}
+				next = append(next, &matrixEntry{keys: keys, values: values})
+			}
+		}
+		entries = next
+	}
+	return entries
+}
+
+func (e *matrixEntry) Label() string {
+	platform := []string{}
+	other := []string{}
+	for _, key := range e.keys {
+		value := e.values[key]
+		switch {
+		case key == "GOOS" || key == "GOARCH":
+			platform = append(platform, value)
+		case value != "":
+			other = append(other, key+"="+value)
+		}
+	}
+	parts := other
+	if len(platform) > 0 {
+		parts = append([]string{strings.Join(platform, "/")}, other...)
+	}
+	return strings.Join(parts, ",")
+}
+
+// The label, made fit for a file name.
+func (e *matrixEntry) fileLabel() string {
+	return strings.NewReplacer("/", "_", ",", "_").Replace(e.Label())
+}
+
+// Replace {KEY} with the entry's value for each key.
+func (e *matrixEntry) expand(text string) string {
+	for _, key := range e.keys {
+		text = strings.Replace(text, "{"+key+"}", e.values[key], -1)
+	}
+	return text
+}
+
+// Everything but "tags" is set in the environment.
+func (e *matrixEntry) env() []string {
+	env := []string{}
+	for _, key := range e.keys {
+		if key != "tags" {
+			env = append(env, key+"="+e.values[key])
+		}
+	}
+	return env
+}
+
+func (e *matrixEntry) command(c *task.CommandTask) *task.CommandTask {
+	args := append([]string{}, c.Args...)
+	if tags := e.values["tags"]; tags != "" && len(args) > 2 {
+		// Flags go right after "go build" and friends.
+		args = append(args[:2], append([]string{"-tags=" + tags}, args[2:]...)...)
+	}
+	env := append(append([]string{}, c.Env...), e.env()...)
+	return &task.CommandTask{Args: args, Env: env, Dir: c.Dir}
+}
+
+// The task, run with this combination.
+func (e *matrixEntry) apply(t task.TaskDecl) (task.TaskDecl, error) {
+	switch t := t.(type) {
+	case *task.CommandTask:
+		return e.command(t), nil
+	case *coverage.Task:
+		copied := *t
+		copied.Test = e.command(t.Test)
+		// Each entry has a profile of its own.
+		copied.Dir = t.Dir + "-" + e.fileLabel()
+		return &copied, nil
+	}
+	return nil, fmt.Errorf("a matrix is not supported for %T", t)
+}
+
+// Entries sharing an output would clean and invalidate each other, so each
+// needs globs of its own.
+func checkMatrixOutputs(name string, outputs []string, entries []*matrixEntry) error {
+	writers := map[string]*matrixEntry{}
+	for _, entry := range entries {
+		for _, output := range outputs {
+			glob := entry.expand(output)
+			if writer, ok := writers[glob]; ok && writer != entry {
+				return fmt.Errorf("%s: more than one matrix entry writes %s, use keys such as {GOOS} in the outputs", name, glob)
+			}
+			writers[glob] = entry
+		}
+	}
+	return nil
+}
+
+// One line per matrix task, such as "build: linux/amd64 ok  windows/amd64 FAIL".
+func printMatrixSummary(w io.Writer, tasks []*TaskWrapper) {
+	lines := map[string][]string{}
+	names := []string{}
+	for _, task := range tasks {
+		if task.Matrix == "" {
+			continue
+		}
+		if lines[task.Matrix] == nil {
+			names = append(names, task.Matrix)
+		}
+		result := "-"
+		if task.record.Result != "" {
+			result = resultText(task.record.Result)
+		}
+		lines[task.Matrix] = append(lines[task.Matrix], task.MatrixLabel+" "+result)
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\n", name, strings.Join(lines[name], "  "))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ncbray/crank/coverage"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/task"
+	"strings"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	entries := expandMatrix(map[string][]string{
+		"tags":   {"", "purego"},
+		"GOARCH": {"amd64", "arm64"},
+		"GOOS":   {"linux"},
+		"X":      {"1"},
+	})
+	labels := []string{}
+	for _, entry := range entries {
+		labels = append(labels, entry.Label())
+	}
+	expected := []string{
+		"linux/amd64,X=1",
+		"linux/amd64,tags=purego,X=1",
+		"linux/arm64,X=1",
+		"linux/arm64,tags=purego,X=1",
+	}
+	if strings.Join(labels, " ") != strings.Join(expected, " ") {
+		t.Fatal(labels)
+	}
+	if strings.Join(entries[1].env(), " ") != "GOOS=linux GOARCH=amd64 X=1" {
+		t.Fatal(entries[1].env())
+	}
+
+	// Without a platform, the label is just the other values.
+	entries = expandMatrix(map[string][]string{"CGO_ENABLED": {"0"}})
+	if len(entries) != 1 || entries[0].Label() != "CGO_ENABLED=0" {
+		t.Fatal(entries)
+	}
+}
+
+func TestMatrixCommand(t *testing.T) {
+	entry := expandMatrix(map[string][]string{"GOOS": {"windows"}, "tags": {"a,b"}})[0]
+	base := &task.CommandTask{Args: []string{"go", "test", "./..."}, Env: []string{"A=1"}, Dir: "src/pkg"}
+	command := entry.command(base)
+	if strings.Join(command.Args, " ") != "go test -tags=a,b ./..." {
+		t.Fatal(command.Args)
+	}
+	if strings.Join(command.Env, " ") != "A=1 GOOS=windows" || command.Dir != "src/pkg" {
+		t.Fatal(command.Env, command.Dir)
+	}
+	if len(base.Args) != 3 || len(base.Env) != 1 {
+		t.Fatal("base modified", base.Args, base.Env)
+	}
+
+	// Too short for flags.
+	short := entry.command(&task.CommandTask{Args: []string{"make"}})
+	if strings.Join(short.Args, " ") != "make" {
+		t.Fatal(short.Args)
+	}
+}
+
+func TestMatrixApply(t *testing.T) {
+	entries := expandMatrix(map[string][]string{"GOOS": {"linux", "windows"}, "GOARCH": {"amd64"}})
+	base := &coverage.Task{Test: task.Command("go", "test", "./..."), Dir: ".crank/coverage"}
+	dirs := map[string]bool{}
+	for _, entry := range entries {
+		applied, err := entry.apply(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs[applied.(*coverage.Task).Dir] = true
+	}
+	if !dirs[".crank/coverage-linux_amd64"] || !dirs[".crank/coverage-windows_amd64"] {
+		t.Fatal(dirs)
+	}
+	if base.Dir != ".crank/coverage" {
+		t.Fatal(base.Dir)
+	}
+	_, err := entries[0].apply(&task.SequenceTask{})
+	if err == nil {
+		t.Fatal("unsupported task applied")
+	}
+}
+
+func TestMatrixOutputs(t *testing.T) {
+	entries := expandMatrix(map[string][]string{"GOOS": {"linux", "windows"}, "GOARCH": {"amd64"}})
+	if entries[1].expand("bin/{GOOS}_{GOARCH}/*") != "bin/windows_amd64/*" {
+		t.Fatal(entries[1].expand("bin/{GOOS}_{GOARCH}/*"))
+	}
+	err := checkMatrixOutputs("build", []string{"bin/{GOOS}/*", "bin/{GOOS}/*"}, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkMatrixOutputs("build", []string{"bin/{GOARCH}/*"}, entries)
+	if err == nil || !strings.Contains(err.Error(), "bin/amd64/*") {
+		t.Fatal(err)
+	}
+
+	// Each entry watches and cleans only its own outputs.
+	workspace, config := makeWorkspace(t)
+	config.Tasks = map[string]*TaskConfig{"build": {Outputs: []string{"bin/{GOOS}/*"}}}
+	linux, _, err := outputGlobs(workspace, config, "build", entries[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !linux.Match("src/pkg/bin/linux/pkg") || linux.Match("src/pkg/bin/windows/pkg.exe") {
+		t.Fatal(linux.Matches)
+	}
+}
+
+func TestPrintMatrixSummary(t *testing.T) {
+	var out bytes.Buffer
+	printMatrixSummary(&out, []*TaskWrapper{
+		{Name: "vet"},
+		{Matrix: "build", MatrixLabel: "linux/amd64", record: history.NodeRecord{Result: history.Success}},
+		{Matrix: "build", MatrixLabel: "windows/amd64", record: history.NodeRecord{Result: history.Error}},
+		{Matrix: "test", MatrixLabel: "tags=purego"},
+	})
+	expected := "build: linux/amd64 ok  windows/amd64 FAIL\ntest: tags=purego -\n"
+	if out.String() != expected {
+		t.Fatal(out.String())
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/bmatcuk/doublestar"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileStamp struct {
+	Exists  bool
+	Size    int64
+	ModTime time.Time
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{Exists: true, Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// The directory part of a glob, before any wildcards.
+func globBase(glob string) string {
+	parts := strings.Split(glob, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[{") {
+			return strings.Join(parts[:i], "/")
+		}
+	}
+	return filepath.ToSlash(filepath.Dir(glob))
+}
+
+// The longest prefix of a workspace relative directory that exists.
+func existingDir(workspaceDir string, dir string) string {
+	for dir != "" && dir != "." && dir != "/" {
+		info, err := os.Stat(filepath.Join(workspaceDir, filepath.FromSlash(dir)))
+		if err == nil && info.IsDir() {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	return dir
+}
+
+// Files currently matching the task's output globs.
+func (w *TaskWrapper) outputFiles() []string {
+	files := []string{}
+	if w.Outputs == nil {
+		return files
+	}
+	for _, match := range w.Outputs.Matches {
+		if match.Invert {
+			continue
+		}
+		// TODO check error
+		paths, _ := doublestar.Glob(match.Glob)
+		for _, path := range paths {
+			path = filepath.ToSlash(path)
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || !w.Outputs.Match(path) {
+				continue
+			}
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// Remember what the outputs look like, so our own writes can be told apart
+// from someone else's.
+func (w *TaskWrapper) snapshotOutputs() {
+	w.outputs = map[string]fileStamp{}
+	if w.Outputs == nil {
+		return
+	}
+	for _, path := range w.outputFiles() {
+		w.outputs[path] = stampFile(path)
+	}
+}
+
+// Create the directories the outputs go in, which need not exist until the
+// task first runs.
+func (w *TaskWrapper) makeOutputDirs() {
+	for _, match := range w.Outputs.Matches {
+		if match.Invert {
+			continue
+		}
+		base := globBase(match.Glob)
+		if base == "" || base == "." {
+			continue
+		}
+		err := os.MkdirAll(filepath.FromSlash(base), 0777)
+		if err != nil {
+			w.Log.LogError("Could not create %s: %s", base, err)
+		}
+	}
+}
+
+func (w *TaskWrapper) cleanOutputs() {
+	for _, path := range w.outputFiles() {
+		err := os.Remove(path)
+		if err != nil {
+			w.Log.LogError("Could not clean %s: %s", path, err)
+		}
+	}
+}
+
+func (w *TaskWrapper) IsOutput(path string) bool {
+	return w.Outputs != nil && w.Outputs.Match(path)
+}
+
+// Has an output changed since the task last ran?
+func (w *TaskWrapper) OutputChanged(path string) bool {
+	stamp := stampFile(path)
+	old, ok := w.outputs[path]
+	if !ok {
+		// Files that come and go during a run are not interesting.
+		return stamp.Exists
+	}
+	return stamp.Exists != old.Exists || stamp.Size != old.Size || !stamp.ModTime.Equal(old.ModTime)
+}
+
+// Is this an event for an output that is just as its task left it?
+func (fm *FileManager) UnchangedOutput(path string) bool {
+	isOutput := false
+	for _, task := range fm.Tasks {
+		if task.IsOutput(path) {
+			if task.OutputChanged(path) {
+				return false
+			}
+			isOutput = true
+		}
+	}
+	return isOutput
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/ncbray/crank/task"
+	"github.com/ncbray/crank/watch"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGlobBase(t *testing.T) {
+	for _, tc := range []struct {
+		glob     string
+		expected string
+	}{
+		{"bin/*", "bin"},
+		{"out/**/*.txt", "out"},
+		{"a/b/file.txt", "a/b"},
+		{"gen/{a,b}/x", "gen"},
+		{"*.pb.go", ""},
+		{"file.txt", "."},
+	} {
+		if globBase(tc.glob) != tc.expected {
+			t.Fatal(tc.glob, globBase(tc.glob))
+		}
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files ...string) {
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(file), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A task writing out/a.txt, with out/keep.txt excluded from its outputs.
+func outputWrapper(t *testing.T) (*TaskWrapper, string) {
+	dir := filepath.ToSlash(t.TempDir())
+	out := dir + "/out"
+	return &TaskWrapper{
+		Name:  "gen",
+		Task:  task.Command("sh", "-c", "echo a > "+out+"/a.txt"),
+		Log:   &task.NullLog{},
+		Match: &CascadingPathMatch{},
+		Outputs: &CascadingPathMatch{Matches: []PathMatch{
+			{Glob: out + "/**/*.txt"},
+			{Glob: out + "/keep.txt", Invert: true},
+		}},
+	}, out
+}
+
+func TestOutputFiles(t *testing.T) {
+	w, out := outputWrapper(t)
+	writeFiles(t, out, "a.txt", "sub/b.txt", "keep.txt", "c.log")
+	files := w.outputFiles()
+	sort.Strings(files)
+	if strings.Join(files, " ") != out+"/a.txt "+out+"/sub/b.txt" {
+		t.Fatal(files)
+	}
+	if len((&TaskWrapper{}).outputFiles()) != 0 {
+		t.Fatal("outputs without globs")
+	}
+}
+
+func TestIgnoreOwnWrites(t *testing.T) {
+	w, out := outputWrapper(t)
+	fm := &FileManager{Tasks: []*TaskWrapper{w}}
+	if !w.Run() {
+		t.Fatal("task failed")
+	}
+	path := out + "/a.txt"
+	if !fm.UnchangedOutput(path) || w.OutputChanged(path) {
+		t.Fatal("own write seen as a change")
+	}
+	// Not an output at all.
+	if fm.UnchangedOutput(out + "/keep.txt") {
+		t.Fatal("excluded file treated as an output")
+	}
+
+	// Someone else rewriting the output is a change.
+	writeFiles(t, out, "a.txt")
+	if fm.UnchangedOutput(path) || !w.OutputChanged(path) {
+		t.Fatal("outside write not seen")
+	}
+	// A new output appearing is too, and one vanishing.
+	writeFiles(t, out, "new.txt")
+	if !w.OutputChanged(out + "/new.txt") {
+		t.Fatal("new output not seen")
+	}
+	w.Run()
+	os.Remove(path)
+	if !w.OutputChanged(path) {
+		t.Fatal("removed output not seen")
+	}
+}
+
+func TestCleanBeforeRerun(t *testing.T) {
+	w, out := outputWrapper(t)
+	w.Clean = true
+	// The output directory does not exist until the task runs.
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if !w.Run() {
+		t.Fatal("task failed")
+	}
+	writeFiles(t, out, "stale.txt", "keep.txt")
+	if !w.Run() {
+		t.Fatal("task failed")
+	}
+	if _, err := os.Stat(out + "/stale.txt"); !os.IsNotExist(err) {
+		t.Fatal("stale output not cleaned")
+	}
+	for _, file := range []string{"a.txt", "keep.txt"} {
+		if _, err := os.Stat(out + "/" + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOutputGlobsWatchExistingDir(t *testing.T) {
+	workspace, config := makeWorkspace(t)
+	config.Tasks = map[string]*TaskConfig{
+		"gen": {Outputs: []string{"../shared/gen/*.go"}},
+	}
+	roots := []watch.Root{{Path: filepath.Join(workspace, "src/pkg"), Recursive: true}}
+	match, roots, err := outputGlobs(workspace, config, "gen", nil, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(match.Matches) != 1 || match.Matches[0].Glob != "src/shared/gen/*.go" {
+		t.Fatal(match.Matches)
+	}
+	// Nothing is created, the existing parent is watched instead.
+	if _, err := os.Stat(filepath.Join(workspace, "src/shared/gen")); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[1].Path != filepath.Join(workspace, "src/shared") || !roots[1].Recursive {
+		t.Fatal(roots)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/history"
+	"github.com/ncbray/crank/stats"
+	"log"
+	"sort"
+	"time"
+)
+
+// Durations of a task's runs, oldest first.  Failures are left out since
+// they often stop early, and cache hits since they did not really run.
+func taskDurations(runs []*history.RunRecord, taskName string) []time.Duration {
+	durations := []time.Duration{}
+	for _, run := range runs {
+		node := run.Node(taskName)
+		if node != nil && node.Result != history.Error && !node.Cached {
+			durations = append(durations, node.Duration)
+		}
+	}
+	return durations
+}
+
+// Warn about tasks that took much longer than they usually do.  Must be
+// called before the run is recorded.
+func (runner *IncrementalTaskRunner) reportSlowTasks() error {
+	if runner.History == nil {
+		return nil
+	}
+	runs, err := runner.History.Runs()
+	if err != nil {
+		return err
+	}
+	for _, task := range runner.FileManager.Tasks {
+		if !task.ran || task.record.Result == history.Error || task.record.Cached {
+			continue
+		}
+		slow, median := runner.SlowCheck.Slow(taskDurations(runs, task.Name), task.record.Duration)
+		if slow {
+			fmt.Printf("Slow: %s took %s, usually %s\n", task.Name, roundDuration(task.record.Duration), roundDuration(median))
+		}
+	}
+	return nil
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(time.Millisecond)
+}
+
+func statsCommand(args []string) {
+	dir := ""
+	configPath := ""
+	pkg := ""
+	taskName := ""
+	limit := 100
+
+	app := cmdline.MakeApp("crank stats")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "dir",
+			Value: cmdline.String.Set(&dir),
+		},
+		{
+			Long:  "config",
+			Value: cmdline.String.Set(&configPath),
+		},
+		{
+			Long:  "package",
+			Value: cmdline.String.Set(&pkg),
+		},
+		{
+			Long:  "task",
+			Value: cmdline.String.Set(&taskName),
+		},
+		{
+			Long:  "limit",
+			Value: cmdline.Int.Set(&limit),
+		},
+	})
+	app.Run(args)
+
+	store := &history.Store{Dir: dir}
+	if dir == "" {
+		store = configHistoryStore(configPath, pkg)
+	}
+
+	runs, err := store.Runs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[len(runs)-limit:]
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, run := range runs {
+		for _, node := range run.Nodes {
+			if !seen[node.Task] && (taskName == "" || node.Task == taskName) {
+				seen[node.Task] = true
+				names = append(names, node.Task)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	check := stats.DefaultSlowCheck
+	fmt.Printf("%-12s %5s %10s %10s %10s %10s %10s\n", "task", "runs", "last", "mean", "ewma", "p50", "p90")
+	for _, name := range names {
+		durations := taskDurations(runs, name)
+		if len(durations) == 0 {
+			continue
+		}
+		s := stats.Summarize(durations)
+		flag := ""
+		if slow, _ := check.Slow(durations[:len(durations)-1], s.Last); slow {
+			flag = " SLOW"
+		}
+		fmt.Printf("%-12s %5d %10s %10s %10s %10s %10s%s\n", name, s.Count,
+			roundDuration(s.Last), roundDuration(s.Mean), roundDuration(s.EWMA),
+			roundDuration(s.P50), roundDuration(s.P90), flag)
+	}
+}
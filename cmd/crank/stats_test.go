@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/ncbray/crank/history"
+	"testing"
+	"time"
+)
+
+func TestTaskDurations(t *testing.T) {
+	runs := []*history.RunRecord{
+		{Nodes: []*history.NodeRecord{{Task: "test", Result: history.Success, Duration: 3 * time.Second}}},
+		{Nodes: []*history.NodeRecord{{Task: "test", Result: history.Success, Duration: 3 * time.Millisecond, Cached: true}}},
+		{Nodes: []*history.NodeRecord{{Task: "test", Result: history.Error, Duration: time.Second}}},
+		{Nodes: []*history.NodeRecord{{Task: "vet", Result: history.Success, Duration: time.Second}}},
+		{Nodes: []*history.NodeRecord{{Task: "test", Result: history.Flaky, Duration: 4 * time.Second}}},
+	}
+	durations := taskDurations(runs, "test")
+	if len(durations) != 2 || durations[0] != 3*time.Second || durations[1] != 4*time.Second {
+		t.Fatal(durations)
+	}
+}
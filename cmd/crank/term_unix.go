@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func stty(args ...string) ([]byte, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Output()
+}
+
+// Deliver key presses as they happen, without echoing them, and return a
+// function that puts the terminal back.  Unlike raw mode, output and ^C are
+// left alone.
+func cbreak() func() {
+	saved, err := stty("-g")
+	if err != nil {
+		// Not a terminal.
+		return func() {}
+	}
+	_, err = stty("-icanon", "-echo", "min", "1")
+	if err != nil {
+		return func() {}
+	}
+	return func() {
+		stty(strings.TrimSpace(string(saved)))
+	}
+}
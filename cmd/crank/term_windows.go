@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+// The console stays line buffered, so keys need to be followed by enter.
+func cbreak() func() {
+	return func() {}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/remote"
+	"log"
+	"net"
+	"os"
+)
+
+// Connect to the workers in the config, if any.
+func dialWorkers(config *Config) (*remote.Pool, error) {
+	if len(config.Workers) == 0 {
+		return nil, nil
+	}
+	token := config.WorkerToken
+	if token == "" {
+		token = os.Getenv(remote.TokenEnv)
+	}
+	conns := []*remote.Conn{}
+	for _, addr := range config.Workers {
+		c, err := remote.Dial(addr, token)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c)
+	}
+	return remote.NewPool(conns...), nil
+}
+
+func workerCommand(args []string) {
+	listen := "localhost:7878"
+	dir := ""
+	token := os.Getenv(remote.TokenEnv)
+
+	app := cmdline.MakeApp("crank worker")
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "listen",
+			Value: cmdline.String.Set(&listen),
+		},
+		{
+			Long:  "dir",
+			Value: cmdline.String.Set(&dir),
+		},
+		{
+			Long:  "token",
+			Value: cmdline.String.Set(&token),
+		},
+	})
+	app.Run(args)
+
+	// Commands run relative to the worker's workspace.
+	if dir != "" {
+		err := os.Chdir(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	network, address := remote.ParseAddr(listen)
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Anyone who can connect can run commands.
+	if token == "" && remote.Exposed(listener.Addr()) {
+		listener.Close()
+		log.Fatalf("refusing to listen on %s without --token or $%s", listener.Addr(), remote.TokenEnv)
+	}
+	ctx := signalContext()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	fmt.Println("crank worker listening on", network, listener.Addr())
+	err = remote.Serve(listener, token)
+	if ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
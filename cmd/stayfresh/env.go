@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parse a .env file of KEY=VALUE lines into environment entries.  Blank
+// lines, comments, an "export " prefix, and quoted values are understood.
+func parseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := []string{}
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineno)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		switch {
+		case strings.HasPrefix(value, "\""):
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, lineno, err)
+			}
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("%s:%d: unterminated quote", path, lineno)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			// Trailing comments are only allowed on unquoted values.
+			hash := strings.Index(value, " #")
+			if hash >= 0 {
+				value = strings.TrimSpace(value[:hash])
+			}
+		}
+		env = append(env, key+"="+value)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Substitute {port} and {restart} in the arguments.
+func expandArgs(args []string, port int, restart int) []string {
+	replacer := strings.NewReplacer(
+		"{port}", strconv.Itoa(port),
+		"{restart}", strconv.Itoa(restart),
+	)
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = replacer.Replace(arg)
+	}
+	return expanded
+}
+
+func usesPort(args []string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, "{port}") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEnvFile(text string, t *testing.T) string {
+	path := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(path, []byte(text), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseEnvFile(t *testing.T) {
+	for _, tc := range []struct {
+		text     string
+		expected []string
+	}{
+		{"", []string{}},
+		{"# comment\n\n  \n", []string{}},
+		{"A=1\nB = two\n", []string{"A=1", "B=two"}},
+		{"export A=1\n", []string{"A=1"}},
+		{"A=\n", []string{"A="}},
+		{"A=x=y\n", []string{"A=x=y"}},
+		{"A=1 # trailing\n", []string{"A=1"}},
+		{"A=1#not a comment\n", []string{"A=1#not a comment"}},
+		{`A="a b # c"` + "\n", []string{"A=a b # c"}},
+		{`A="line\nbreak"` + "\n", []string{"A=line\nbreak"}},
+		{"A='$HOME \\n'\n", []string{"A=$HOME \\n"}},
+	} {
+		env, err := parseEnvFile(writeEnvFile(tc.text, t))
+		if err != nil {
+			t.Fatal(tc.text, err)
+		}
+		if strings.Join(env, "|") != strings.Join(tc.expected, "|") || len(env) != len(tc.expected) {
+			t.Fatal(tc.text, env)
+		}
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	for _, text := range []string{
+		"no equals\n",
+		"=value\n",
+		`A="unterminated` + "\n",
+		"A='unterminated\n",
+		"A='\n",
+	} {
+		_, err := parseEnvFile(writeEnvFile(text, t))
+		if err == nil {
+			t.Fatal(text)
+		}
+	}
+	_, err := parseEnvFile(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Fatal("missing file")
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"--port={port}", "-v"}, []string{"--port=8080", "-v"}},
+		{[]string{"{port}{port}", "run-{restart}"}, []string{"80808080", "run-3"}},
+		{[]string{"{PORT}", "{other}"}, []string{"{PORT}", "{other}"}},
+	} {
+		expanded := expandArgs(tc.args, 8080, 3)
+		if strings.Join(expanded, " ") != strings.Join(tc.expected, " ") || len(expanded) != len(tc.expected) {
+			t.Fatal(tc.args, expanded)
+		}
+	}
+
+	args := []string{"{port}"}
+	expandArgs(args, 1, 0)
+	if args[0] != "{port}" {
+		t.Fatal("modified in place")
+	}
+}
+
+func TestUsesPort(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"-v"}, false},
+		{[]string{"{restart}"}, false},
+		{[]string{"-v", "--addr=:{port}"}, true},
+	} {
+		if usesPort(tc.args) != tc.expected {
+			t.Fatal(tc.args)
+		}
+	}
+}
@@ -1,58 +1,471 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/ncbray/cmdline"
+	"github.com/ncbray/crank/task"
 	"github.com/ncbray/crank/watch"
+	"io"
+	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	sig, ok := signalNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %#v", name)
+	}
+	return sig, nil
+}
+
+// Processes that exit sooner than this after starting count as crashes.
+const crashWindow = 10 * time.Second
+
+const maxBackoff = time.Minute
+
 type stayfresh struct {
 	executable string
 	args       []string
-	cmd        *exec.Cmd
+	// Paths that trigger a restart when they change.
+	roots []watch.Root
+	// Where stayfresh's own messages go.
+	out    io.Writer
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// Sent to the process group to ask it to exit.
+	signal syscall.Signal
+	// How long to wait after signal before killing the process group.
+	timeout time.Duration
+	// If set, run before each restart and only restart if it succeeds.
+	build task.TaskDecl
+	log   task.TaskLog
+	// Restart the process if it exits on its own.
+	restart bool
+	// Delay before the first restart, doubled for each consecutive crash.
+	backoff time.Duration
+	// Give up restarting after this many consecutive crashes.
+	crashLimit int
+	// If set, restarts hand traffic over from the old process to the new one.
+	proxy *proxy
+	// Environment variable telling the process which port to listen on.
+	portEnv string
+	// KEY=VALUE lines added to the environment, reloaded on each restart.
+	envFile string
+	// Readiness probes, any of which may be empty.
+	readyTCP     string
+	readyHTTP    string
+	readyLog     *regexp.Regexp
+	readyTimeout time.Duration
+
+	// Guards the fields below, which the exit monitor also touches.
+	mutex    sync.Mutex
+	current  *process
+	crashes  int
+	pending  *time.Timer
+	restarts int
 }
 
 func (s *stayfresh) printableCmd() string {
 	return strings.Join(append([]string{s.executable}, s.args...), " ")
 }
 
+// Fresh probes for a new process, which must listen on addr if set.
+func (s *stayfresh) probes(addr string) []probe {
+	probes := []probe{}
+	if addr != "" {
+		probes = append(probes, &tcpProbe{addr: addr})
+	}
+	if s.readyTCP != "" {
+		probes = append(probes, &tcpProbe{addr: s.readyTCP})
+	}
+	if s.readyHTTP != "" {
+		probes = append(probes, &httpProbe{url: s.readyHTTP})
+	}
+	if s.readyLog != nil {
+		probes = append(probes, &logProbe{pattern: s.readyLog})
+	}
+	return probes
+}
+
+// Start the command, listening on port if it is not zero.
+func (s *stayfresh) start(port int, probes []probe) *process {
+	env := []string{}
+	if s.envFile != "" {
+		// Reread every time so edits take effect on restart.
+		fileEnv, err := parseEnvFile(s.envFile)
+		if err != nil {
+			fmt.Fprintln(s.out, "stayfresh:", err)
+			return nil
+		}
+		env = append(env, fileEnv...)
+	}
+	if port != 0 {
+		env = append(env, fmt.Sprintf("%s=%d", s.portEnv, port))
+	}
+	args := expandArgs(s.args, port, s.restarts)
+	s.restarts += 1
+
+	fmt.Fprintln(s.out, "stayfresh run:", strings.Join(append([]string{s.executable}, args...), " "))
+	fmt.Fprintln(s.out)
+	cmd := exec.Command(s.executable, args...)
+	cmd.Stdin = s.stdin
+	cmd.Stdout = s.stdout
+	cmd.Stderr = s.stderr
+	// Line writers hold on to any final line without a newline until
+	// the process exits.
+	lines := []*lineWriter{}
+	for _, w := range []io.Writer{s.stdout, s.stderr} {
+		if lw, ok := w.(*lineWriter); ok {
+			lines = append(lines, lw)
+		}
+	}
+	for _, pr := range probes {
+		if lp, ok := pr.(*logProbe); ok {
+			lw := &lineWriter{line: lp.Line}
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, lw)
+			lines = append(lines, lw)
+		}
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	p, err := startProcess(cmd, func(p *process) {
+		for _, lw := range lines {
+			lw.Flush()
+		}
+		s.exited(p)
+	})
+	if err != nil {
+		fmt.Fprintln(s.out, "stayfresh: could not start:", err)
+		return nil
+	}
+	return p
+}
+
+func (s *stayfresh) ready(p *process, probes []probe) bool {
+	if len(probes) == 0 {
+		return true
+	}
+	err := waitReady(p, probes, s.readyTimeout)
+	if err != nil {
+		fmt.Fprintln(s.out, "stayfresh:", err)
+		return false
+	}
+	fmt.Fprintln(s.out, "stayfresh: ready after", time.Since(p.start).Round(100*time.Millisecond))
+	return true
+}
+
+// Start a new process in place of the current one.
 func (s *stayfresh) run() {
-	fmt.Println("stayfresh run:", s.printableCmd())
-	fmt.Println()
-	s.cmd = exec.Command(s.executable, s.args...)
-	s.cmd.Stdin = os.Stdin
-	s.cmd.Stdout = os.Stdout
-	s.cmd.Stderr = os.Stderr
-	s.cmd.Start()
+	s.cancelRestart()
+	if s.proxy == nil {
+		s.kill()
+		port := 0
+		if usesPort(s.args) {
+			var err error
+			port, err = freePort()
+			if err != nil {
+				fmt.Fprintln(s.out, "stayfresh:", err)
+				return
+			}
+		}
+		probes := s.probes("")
+		s.current = s.start(port, probes)
+		if s.current != nil {
+			s.ready(s.current, probes)
+		}
+		return
+	}
+
+	// Bring up the new process next to the old one before switching over.
+	port, err := freePort()
+	if err != nil {
+		fmt.Fprintln(s.out, "stayfresh:", err)
+		return
+	}
+	addr := backendAddr(port)
+	probes := s.probes(addr)
+	p := s.start(port, probes)
+	if p == nil {
+		return
+	}
+	if !s.ready(p, probes) {
+		p.stop(s.signal, s.timeout, s.out)
+		if s.current != nil {
+			fmt.Fprintln(s.out, "stayfresh: keeping", s.printableCmd())
+		}
+		return
+	}
+	s.proxy.setTarget(addr)
+	fmt.Fprintln(s.out, "stayfresh: switched traffic to", addr)
+	s.kill()
+	s.current = p
+}
+
+func (s *stayfresh) exited(p *process) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if p != s.current || p.stopping {
+		return
+	}
+	s.current = nil
+
+	fmt.Fprintln(s.out)
+	fmt.Fprintln(s.out, "stayfresh exit:", s.printableCmd(), "-", p.exitStatus())
+	if !s.restart {
+		fmt.Fprintln(s.out, "stayfresh: waiting for changes")
+		return
+	}
+
+	if time.Since(p.start) < crashWindow {
+		s.crashes += 1
+	} else {
+		s.crashes = 0
+	}
+	if s.crashLimit > 0 && s.crashes >= s.crashLimit {
+		fmt.Fprintf(s.out, "stayfresh: crashed %d times in a row, not restarting until something changes\n", s.crashes)
+		return
+	}
+
+	delay := time.Duration(0)
+	if s.crashes > 0 {
+		delay = s.backoff << uint(s.crashes-1)
+		if delay <= 0 || delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+	fmt.Fprintln(s.out, "stayfresh: restarting in", delay)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.pending == timer && s.current == nil {
+			s.run()
+		}
+	})
+	s.pending = timer
+}
+
+func (s *stayfresh) runBuild() bool {
+	if s.build == nil {
+		return true
+	}
+	log := s.log.CreateSubtask("build")
+	start := time.Now()
+	log.Begin(start)
+	ok := s.build.Run(log)
+	end := time.Now()
+	log.End(end, end.Sub(start))
+	return ok
 }
 
 func (s *stayfresh) Begin() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.runBuild() {
+		fmt.Fprintln(s.out, "stayfresh: build failed, not starting", s.printableCmd())
+		return
+	}
 	s.run()
 }
 
+// Is path inside one of the process's roots?
+func (s *stayfresh) watches(path string) bool {
+	return insideRoots(s.roots, path)
+}
+
+func insideRoots(roots []watch.Root, path string) bool {
+	for _, root := range roots {
+		rootPath, err := filepath.Abs(root.Path)
+		if err != nil {
+			continue
+		}
+		if path == rootPath {
+			return true
+		}
+		if root.Recursive && strings.HasPrefix(path, rootPath+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *stayfresh) FileChanged(path string) bool {
+	if s.build != nil {
+		// The build writes the executable, which should not trigger another build.
+		executable, err := filepath.Abs(s.executable)
+		if err == nil && path == executable {
+			return false
+		}
+	}
 	return true
 }
 
+func (s *stayfresh) cancelRestart() {
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+}
+
+func (s *stayfresh) kill() {
+	s.cancelRestart()
+	if s.current == nil {
+		return
+	}
+	p := s.current
+	s.current = nil
+
+	fmt.Fprintln(s.out)
+	fmt.Fprintln(s.out, "stayfresh stop:", s.printableCmd())
+	p.stop(s.signal, s.timeout, s.out)
+}
+
 func (s *stayfresh) Idle() {
-	s.cmd.Process.Kill()
-	s.cmd.Wait()
-	fmt.Println()
-	fmt.Println("stayfresh kill:", s.printableCmd())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Something changed, so give crashing processes another chance.
+	s.crashes = 0
+
+	if !s.runBuild() {
+		if s.current != nil {
+			fmt.Fprintln(s.out, "stayfresh: build failed, keeping", s.printableCmd())
+		} else {
+			fmt.Fprintln(s.out, "stayfresh: build failed, not starting", s.printableCmd())
+		}
+		return
+	}
 	s.run()
 }
 
+func (s *stayfresh) Shutdown() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.kill()
+}
+
+// Cancelled when the process is asked to shut down.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
+
 func main() {
 	var executable string
 	args := []string{}
+	signalName := "TERM"
+	timeoutText := "5s"
+	buildText := ""
+	watchPaths := []string{}
+	restart := false
+	backoffText := "1s"
+	crashLimit := 5
+	proxyAddr := ""
+	portEnv := "PORT"
+	readyTCP := ""
+	readyHTTP := ""
+	readyLogText := ""
+	readyTimeoutText := "30s"
+	procfile := false
+	envFile := ""
 
 	app := cmdline.MakeApp("stayfresh")
-	executableFile := &cmdline.FilePath{
-		MustExist: true,
-	}
+	app.Flags([]*cmdline.Flag{
+		{
+			Long:  "signal",
+			Value: cmdline.String.Set(&signalName),
+		},
+		{
+			Long:  "timeout",
+			Value: cmdline.String.Set(&timeoutText),
+		},
+		{
+			Long:  "build",
+			Value: cmdline.String.Set(&buildText),
+		},
+		{
+			Long:  "restart",
+			Value: cmdline.Bool.Set(&restart),
+		},
+		{
+			Long:  "backoff",
+			Value: cmdline.String.Set(&backoffText),
+		},
+		{
+			Long:  "crash-limit",
+			Value: cmdline.Int.Set(&crashLimit),
+		},
+		{
+			Long:  "proxy",
+			Value: cmdline.String.Set(&proxyAddr),
+		},
+		{
+			Long:  "port-env",
+			Value: cmdline.String.Set(&portEnv),
+		},
+		{
+			Long:  "ready-tcp",
+			Value: cmdline.String.Set(&readyTCP),
+		},
+		{
+			Long:  "ready-http",
+			Value: cmdline.String.Set(&readyHTTP),
+		},
+		{
+			Long:  "ready-log",
+			Value: cmdline.String.Set(&readyLogText),
+		},
+		{
+			Long:  "ready-timeout",
+			Value: cmdline.String.Set(&readyTimeoutText),
+		},
+		{
+			Long:  "env-file",
+			Value: cmdline.String.Set(&envFile),
+		},
+		{
+			Long:  "procfile",
+			Value: cmdline.Bool.Set(&procfile),
+		},
+		{
+			Long: "watch",
+			Value: cmdline.String.Call(func(value string) {
+				watchPaths = append(watchPaths, value)
+			}),
+		},
+	})
+	// With --build the executable may not exist until the first build.
+	// With --procfile it names the Procfile instead.
+	executableFile := &cmdline.FilePath{}
 	app.RequiredArgs([]*cmdline.Argument{
 		{
 			Name:  "executable",
@@ -67,12 +480,107 @@ func main() {
 	})
 	app.Run(os.Args[1:])
 
-	err := watch.WatchFiles(executable, &stayfresh{
+	sig, err := parseSignal(signalName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeout, err := time.ParseDuration(timeoutText)
+	if err != nil {
+		log.Fatal(err)
+	}
+	backoff, err := time.ParseDuration(backoffText)
+	if err != nil {
+		log.Fatal(err)
+	}
+	readyTimeout, err := time.ParseDuration(readyTimeoutText)
+	if err != nil {
+		log.Fatal(err)
+	}
+	buildArgs := strings.Fields(buildText)
+	if buildText != "" && len(buildArgs) == 0 {
+		log.Fatal("--build needs a command")
+	}
+
+	s := &stayfresh{
 		executable: executable,
 		args:       args,
-	})
+		out:        os.Stdout,
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		signal:     sig,
+		timeout:    timeout,
+		log:        task.MakeConsoleLog(),
+		restart:    restart,
+		backoff:    backoff,
+		crashLimit: crashLimit,
+		portEnv:    portEnv,
+		envFile:    envFile,
+
+		readyTCP:     readyTCP,
+		readyHTTP:    readyHTTP,
+		readyTimeout: readyTimeout,
+	}
+
+	if procfile {
+		if buildText != "" || proxyAddr != "" || readyTCP != "" || readyHTTP != "" || readyLogText != "" || len(watchPaths) > 0 {
+			log.Fatal("--procfile cannot be combined with --build, --proxy, --watch, or --ready-*")
+		}
+		entries, err := parseProcfile(executable)
+		if err != nil {
+			log.Fatal(err)
+		}
+		o := makeProcfileObserver(entries, s)
+		if envFile != "" {
+			o.shared = append(o.shared, watch.Root{Path: envFile})
+		}
+		err = watch.WatchRoots(signalContext(), o.Roots(), o)
+		o.Shutdown()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if readyLogText != "" {
+		s.readyLog, err = regexp.Compile(readyLogText)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if proxyAddr != "" {
+		s.proxy, err = listenProxy(proxyAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.proxy.Close()
+		// The old and new processes overlap, and cannot share the terminal.
+		s.stdin = nil
+	}
+
+	if buildText != "" {
+		s.build = task.Command(buildArgs...)
+		if len(watchPaths) == 0 {
+			watchPaths = append(watchPaths, ".")
+		}
+	} else {
+		_, err := os.Stat(executable)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.roots = append(s.roots, watch.Root{Path: executable})
+	}
+	for _, path := range watchPaths {
+		s.roots = append(s.roots, watch.Root{Path: path, Recursive: true})
+	}
+	if envFile != "" {
+		s.roots = append(s.roots, watch.Root{Path: envFile})
+	}
+
+	err = watch.WatchRoots(signalContext(), s.roots, s)
+	s.Shutdown()
 
 	if err != nil {
-		panic(nil)
+		panic(err)
 	}
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ncbray/crank/task"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A buffer that stayfresh's goroutines can write while the test reads it.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func testStayfresh(out io.Writer, executable string, args ...string) *stayfresh {
+	return &stayfresh{
+		executable: executable,
+		args:       args,
+		out:        out,
+		stdout:     io.Discard,
+		stderr:     io.Discard,
+		signal:     syscall.SIGTERM,
+		timeout:    time.Second,
+		log:        &task.NullLog{},
+	}
+}
+
+func (s *stayfresh) currentProcess() *process {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.current
+}
+
+func TestBuildGatesRestart(t *testing.T) {
+	out := &syncBuffer{}
+	s := testStayfresh(out, "sleep", "10")
+	defer s.Shutdown()
+
+	s.build = task.Command("false")
+	s.Begin()
+	if s.currentProcess() != nil {
+		t.Fatal("started after a failed build")
+	}
+
+	s.build = task.Command("true")
+	s.Idle()
+	first := s.currentProcess()
+	if first == nil {
+		t.Fatal("not started after a good build")
+	}
+
+	// A broken build leaves the old process running.
+	s.build = task.Command("false")
+	s.Idle()
+	if s.currentProcess() != first {
+		t.Fatal("process replaced after a failed build")
+	}
+	if !strings.Contains(out.String(), "build failed, keeping") {
+		t.Fatal(out.String())
+	}
+
+	s.build = task.Command("true")
+	s.Idle()
+	second := s.currentProcess()
+	if second == nil || second == first {
+		t.Fatal("not restarted after a good build")
+	}
+	select {
+	case <-first.done:
+	default:
+		t.Fatal("old process still running")
+	}
+}
+
+func waitOutput(out *syncBuffer, text string, t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), text) {
+		if time.Now().After(deadline) {
+			t.Fatal(out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	out := &syncBuffer{}
+	s := testStayfresh(out, "false")
+	s.restart = true
+	s.backoff = 10 * time.Millisecond
+	s.crashLimit = 3
+	defer s.Shutdown()
+
+	s.Begin()
+	waitOutput(out, "crashed 3 times in a row", t)
+	text := out.String()
+	for _, line := range []string{"restarting in 10ms", "restarting in 20ms"} {
+		if !strings.Contains(text, line) {
+			t.Fatal(line, text)
+		}
+	}
+	if strings.Contains(text, "restarting in 40ms") {
+		t.Fatal("restarted past the limit", text)
+	}
+}
+
+// A process that has already exited, started at the given time.
+func exitedProcess(start time.Time) *process {
+	p := &process{cmd: exec.Command("false"), start: start, done: make(chan bool), err: errors.New("exit status 1")}
+	close(p.done)
+	return p
+}
+
+func TestCrashBackoff(t *testing.T) {
+	out := &syncBuffer{}
+	s := testStayfresh(out, "sleep", "10")
+	s.restart = true
+	s.backoff = 20 * time.Second
+	defer s.Shutdown()
+
+	// Quick exits double the delay each time, up to maxBackoff.
+	for i, expected := range []string{"20s", "40s", "1m0s", "1m0s"} {
+		p := exitedProcess(time.Now())
+		s.mutex.Lock()
+		s.current = p
+		s.mutex.Unlock()
+		s.exited(p)
+		if s.crashes != i+1 || !strings.HasSuffix(out.String(), "restarting in "+expected+"\n") {
+			t.Fatal(i, s.crashes, out.String())
+		}
+	}
+
+	// A process that ran a while is not a crash.
+	p := exitedProcess(time.Now().Add(-2 * crashWindow))
+	s.mutex.Lock()
+	s.current = p
+	s.mutex.Unlock()
+	s.exited(p)
+	if s.crashes != 0 || !strings.HasSuffix(out.String(), "restarting in 0s\n") {
+		t.Fatal(s.crashes, out.String())
+	}
+
+	// A change gives a crashing process a fresh start.
+	s.mutex.Lock()
+	s.crashes = 3
+	s.mutex.Unlock()
+	s.Idle()
+	if s.crashes != 0 || s.currentProcess() == nil {
+		t.Fatal(s.crashes)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Put the child in its own process group so the whole tree can be signalled,
+// returning whether it was.  A child reading the terminal stays in
+// stayfresh's group, since a background group is stopped by SIGTTIN when it
+// reads.
+func setProcessGroup(cmd *exec.Cmd) bool {
+	if f, ok := cmd.Stdin.(*os.File); ok && isTerminal(f) {
+		return false
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return true
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Signal the process, and its group if it has one.
+func signalProcessGroup(p *os.Process, group bool, sig syscall.Signal) error {
+	if !group {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, sig)
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestProcessGroupStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	cmd := exec.Command("true")
+	cmd.Stdin = r
+	if !setProcessGroup(cmd) || !cmd.SysProcAttr.Setpgid {
+		t.Fatal("no process group")
+	}
+	if cmd.Stdin != r {
+		t.Fatal("pipe dropped")
+	}
+
+	// Stands in for a terminal, as both are character devices.  The child
+	// keeps it, and stays in the foreground group to read it.
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	cmd = exec.Command("true")
+	cmd.Stdin = null
+	if setProcessGroup(cmd) || cmd.SysProcAttr != nil {
+		t.Fatal("terminal reader put in the background")
+	}
+	if cmd.Stdin != null {
+		t.Fatal("terminal dropped")
+	}
+}
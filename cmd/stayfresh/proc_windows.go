@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) bool {
+	return false
+}
+
+// Windows cannot deliver arbitrary signals, so everything is a kill.
+func signalProcessGroup(p *os.Process, group bool, sig syscall.Signal) error {
+	return p.Kill()
+}
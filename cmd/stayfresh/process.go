@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+type process struct {
+	cmd   *exec.Cmd
+	start time.Time
+	// Closed once the process has exited, after which err is valid.
+	done chan bool
+	err  error
+	// Set when stayfresh asked the process to exit.
+	stopping bool
+	// Whether the process leads its own process group.
+	group bool
+}
+
+// Start the command, calling exited from another goroutine once it exits.
+func startProcess(cmd *exec.Cmd, exited func(p *process)) (*process, error) {
+	group := setProcessGroup(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	p := &process{
+		cmd:   cmd,
+		start: time.Now(),
+		done:  make(chan bool),
+		group: group,
+	}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+		exited(p)
+	}()
+	return p, nil
+}
+
+func (p *process) exitStatus() string {
+	if p.cmd.ProcessState != nil {
+		return p.cmd.ProcessState.String()
+	}
+	return fmt.Sprint(p.err)
+}
+
+// Send sig to the process group, escalating to SIGKILL after timeout.
+func (p *process) stop(sig syscall.Signal, timeout time.Duration, out io.Writer) {
+	p.stopping = true
+	signalProcessGroup(p.cmd.Process, p.group, sig)
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		fmt.Fprintln(out, "stayfresh kill: still running after", timeout)
+		signalProcessGroup(p.cmd.Process, p.group, syscall.SIGKILL)
+		<-p.done
+	}
+	// Take down anything the process left behind in its group.
+	if p.group {
+		signalProcessGroup(p.cmd.Process, p.group, syscall.SIGKILL)
+	}
+}
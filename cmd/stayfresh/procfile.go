@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mattn/go-colorable"
+	"github.com/mgutz/ansi"
+	"github.com/ncbray/crank/task"
+	"github.com/ncbray/crank/watch"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type procfileEntry struct {
+	name  string
+	args  []string
+	watch []string
+}
+
+// Parse a Procfile of "name: command args..." lines.  By default a process
+// restarts when its executable changes, a "name.watch: paths..." line
+// replaces that with other files and directories.
+func parseProcfile(path string) ([]*procfileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*procfileEntry{}
+	lookup := map[string]*procfileEntry{}
+	watches := map[string][]string{}
+
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected \"name: command\"", path, lineno)
+		}
+		name := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+		if strings.HasSuffix(name, ".watch") {
+			name = strings.TrimSuffix(name, ".watch")
+			watches[name] = append(watches[name], fields...)
+			continue
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%s:%d: no command for %#v", path, lineno, name)
+		}
+		if lookup[name] != nil {
+			return nil, fmt.Errorf("%s:%d: duplicate process %#v", path, lineno, name)
+		}
+		entry := &procfileEntry{name: name, args: fields}
+		entries = append(entries, entry)
+		lookup[name] = entry
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, paths := range watches {
+		entry := lookup[name]
+		if entry == nil {
+			return nil, fmt.Errorf("%s: watch paths for unknown process %#v", path, name)
+		}
+		entry.watch = paths
+	}
+	for _, entry := range entries {
+		if len(entry.watch) == 0 {
+			// Commands like "npm run dev" are found on the PATH.
+			executable, err := exec.LookPath(entry.args[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s: cannot find %#v to watch, add a \"%s.watch: paths...\" line", path, entry.args[0], entry.name)
+			}
+			entry.watch = []string{executable}
+		}
+	}
+	return entries, nil
+}
+
+// Directories are watched recursively, everything else as a single file.
+func watchRoot(path string) watch.Root {
+	info, err := os.Stat(path)
+	return watch.Root{Path: path, Recursive: err == nil && info.IsDir()}
+}
+
+var prefixColors = []string{"cyan", "magenta", "blue", "yellow", "green", "red"}
+
+// Prefix every line written with the colored process name.
+func makePrefixWriter(child io.Writer, name string, index int) io.Writer {
+	prefix := ansi.Color(name, prefixColors[index%len(prefixColors)]) + " | "
+	wrapped := task.MakeWrappedWriter(child, prefix, "\n")
+	return &lineWriter{line: func(line string) {
+		wrapped.Write([]byte(line))
+	}}
+}
+
+// Restarts each process only when its own inputs change.
+type procfileObserver struct {
+	procs []*stayfresh
+	// Paths that restart every process, such as the env file.
+	shared []watch.Root
+	dirty  map[*stayfresh]bool
+}
+
+func (o *procfileObserver) Begin() {
+	for _, s := range o.procs {
+		s.Begin()
+	}
+}
+
+func (o *procfileObserver) FileChanged(path string) bool {
+	changed := false
+	all := insideRoots(o.shared, path)
+	for _, s := range o.procs {
+		if (all || s.watches(path)) && s.FileChanged(path) {
+			o.dirty[s] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (o *procfileObserver) Idle() {
+	for _, s := range o.procs {
+		if o.dirty[s] {
+			s.Idle()
+		}
+	}
+	o.dirty = map[*stayfresh]bool{}
+}
+
+func (o *procfileObserver) Shutdown() {
+	for _, s := range o.procs {
+		s.Shutdown()
+	}
+}
+
+func (o *procfileObserver) Roots() []watch.Root {
+	roots := append([]watch.Root{}, o.shared...)
+	for _, s := range o.procs {
+		roots = append(roots, s.roots...)
+	}
+	return roots
+}
+
+// Create a stayfresh for each process in the Procfile, using base for the
+// settings they share.
+func makeProcfileObserver(entries []*procfileEntry, base *stayfresh) *procfileObserver {
+	width := 0
+	for _, entry := range entries {
+		if len(entry.name) > width {
+			width = len(entry.name)
+		}
+	}
+	stdout := colorable.NewColorableStdout()
+	stderr := colorable.NewColorableStderr()
+
+	o := &procfileObserver{dirty: map[*stayfresh]bool{}}
+	for i, entry := range entries {
+		name := fmt.Sprintf("%-*s", width, entry.name)
+		s := &stayfresh{
+			executable: entry.args[0],
+			args:       entry.args[1:],
+			out:        makePrefixWriter(stdout, name, i),
+			stdout:     makePrefixWriter(stdout, name, i),
+			stderr:     makePrefixWriter(stderr, name, i),
+			signal:     base.signal,
+			timeout:    base.timeout,
+			restart:    base.restart,
+			backoff:    base.backoff,
+			crashLimit: base.crashLimit,
+			portEnv:    base.portEnv,
+			envFile:    base.envFile,
+			log:        base.log,
+		}
+		for _, path := range entry.watch {
+			s.roots = append(s.roots, watchRoot(path))
+		}
+		o.procs = append(o.procs, s)
+	}
+	return o
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/ncbray/crank/watch"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProcfile(text string, t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "Procfile")
+	err := os.WriteFile(path, []byte(text), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseProcfile(t *testing.T) {
+	path := writeProcfile(`# comment
+
+web: ./bin/web --port $PORT
+web.watch: bin/web templates
+worker:   ./bin/worker
+worker.watch: bin/worker
+`, t)
+	entries, err := parseProcfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal(entries)
+	}
+	web := entries[0]
+	if web.name != "web" || strings.Join(web.args, " ") != "./bin/web --port $PORT" {
+		t.Fatal(web.name, web.args)
+	}
+	if strings.Join(web.watch, " ") != "bin/web templates" {
+		t.Fatal(web.watch)
+	}
+	worker := entries[1]
+	if worker.name != "worker" || strings.Join(worker.args, " ") != "./bin/worker" {
+		t.Fatal(worker.name, worker.args)
+	}
+}
+
+func TestParseProcfileDefaultWatch(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	// A bare command name is watched where it is found on the PATH.
+	entries, err := parseProcfile(writeProcfile("dev: sh -c true\n", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries[0].watch) != 1 || entries[0].watch[0] != sh {
+		t.Fatal(entries[0].watch)
+	}
+
+	_, err = parseProcfile(writeProcfile("web: no-such-command-stayfresh run dev\n", t))
+	if err == nil || !strings.Contains(err.Error(), "web.watch") {
+		t.Fatal(err)
+	}
+}
+
+func TestParseProcfileErrors(t *testing.T) {
+	for _, text := range []string{
+		"no colon\n",
+		": command\n",
+		"web:\n",
+		"web: a\nweb: b\n",
+		"web: a\nweb.watch: a\nother.watch: b\n",
+	} {
+		_, err := parseProcfile(writeProcfile(text, t))
+		if err == nil {
+			t.Fatal(text)
+		}
+	}
+}
+
+func TestProcfileSharedRoots(t *testing.T) {
+	dir := t.TempDir()
+	entries := []*procfileEntry{
+		{name: "web", args: []string{"web"}, watch: []string{filepath.Join(dir, "web")}},
+		{name: "worker", args: []string{"worker"}, watch: []string{filepath.Join(dir, "worker")}},
+	}
+	envFile := filepath.Join(dir, ".env")
+	o := makeProcfileObserver(entries, &stayfresh{envFile: envFile})
+	o.shared = append(o.shared, watch.Root{Path: envFile})
+
+	// The env file is watched once, not once per process.
+	count := 0
+	for _, root := range o.Roots() {
+		if root.Path == envFile {
+			count += 1
+		}
+	}
+	if count != 1 || len(o.Roots()) != 3 {
+		t.Fatal(o.Roots())
+	}
+
+	if !o.FileChanged(filepath.Join(dir, "web")) || len(o.dirty) != 1 || !o.dirty[o.procs[0]] {
+		t.Fatal(o.dirty)
+	}
+	o.dirty = map[*stayfresh]bool{}
+	if !o.FileChanged(envFile) || len(o.dirty) != 2 {
+		t.Fatal(o.dirty)
+	}
+}
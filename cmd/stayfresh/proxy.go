@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Forwards TCP connections to whichever backend is current, so the backend
+// can be swapped without dropping the listening port.
+type proxy struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	target   string
+}
+
+func listenProxy(addr string) (*proxy, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{listener: listener}
+	go p.serve()
+	return p, nil
+}
+
+func (p *proxy) setTarget(target string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.target = target
+}
+
+func (p *proxy) getTarget() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.target
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *proxy) forward(conn net.Conn) {
+	defer conn.Close()
+	target := p.getTarget()
+	if target == "" {
+		return
+	}
+	backend, err := net.Dial("tcp", target)
+	if err != nil {
+		fmt.Println("stayfresh proxy:", err)
+		return
+	}
+	defer backend.Close()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	copyHalf := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		if tcp, ok := dst.(*net.TCPConn); ok && err == nil {
+			// Pass on the half close, the other side may still reply.
+			tcp.CloseWrite()
+			return
+		}
+		// Unblock the other direction.
+		conn.Close()
+		backend.Close()
+	}
+	go copyHalf(backend, conn)
+	go copyHalf(conn, backend)
+	wg.Wait()
+}
+
+func (p *proxy) Close() error {
+	return p.listener.Close()
+}
+
+// Find a port nothing is listening on.  There is a window between closing
+// it and the child binding it, but that is good enough for development.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func backendAddr(port int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// Reads the whole request before replying, like a server that waits for
+// the client to finish sending.
+func serveAfterEOF(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			request, _ := io.ReadAll(conn)
+			conn.Write([]byte("reply to " + string(request)))
+		}()
+	}
+}
+
+func TestProxyHalfClose(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go serveAfterEOF(backend)
+
+	p, err := listenProxy("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.setTarget(backend.Addr().String())
+
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.(*net.TCPConn).CloseWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "reply to hello" {
+		t.Fatal(string(reply))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A readiness check, polled until it passes.
+type probe interface {
+	Ready() bool
+	String() string
+}
+
+type tcpProbe struct {
+	addr string
+}
+
+func (p *tcpProbe) Ready() bool {
+	conn, err := net.DialTimeout("tcp", p.addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (p *tcpProbe) String() string {
+	return "tcp " + p.addr
+}
+
+type httpProbe struct {
+	url string
+}
+
+func (p *httpProbe) Ready() bool {
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(p.url)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func (p *httpProbe) String() string {
+	return "http " + p.url
+}
+
+// Ready once a line of the process's stdout matches.
+type logProbe struct {
+	pattern *regexp.Regexp
+	mutex   sync.Mutex
+	matched bool
+}
+
+func (p *logProbe) Line(line string) {
+	if p.pattern.MatchString(line) {
+		p.mutex.Lock()
+		p.matched = true
+		p.mutex.Unlock()
+	}
+}
+
+func (p *logProbe) Ready() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.matched
+}
+
+func (p *logProbe) String() string {
+	return "log " + p.pattern.String()
+}
+
+// Calls a function for each complete line written.
+type lineWriter struct {
+	line    func(line string)
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Pass on a final line that has no trailing newline, once nothing more
+// will be written.
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.line(strings.TrimSuffix(string(w.partial), "\r"))
+		w.partial = nil
+	}
+}
+
+// Poll the probes until they all pass, the process exits, or timeout.
+func waitReady(p *process, probes []probe, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		failing := []string{}
+		for _, pr := range probes {
+			if !pr.Ready() {
+				failing = append(failing, pr.String())
+			}
+		}
+		if len(failing) == 0 {
+			return nil
+		}
+		select {
+		case <-p.done:
+			return fmt.Errorf("exited before ready - %s", p.exitStatus())
+		case <-deadline:
+			return fmt.Errorf("not ready after %s, waiting on %s", timeout, strings.Join(failing, ", "))
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	lines := []string{}
+	w := &lineWriter{line: func(line string) {
+		lines = append(lines, line)
+	}}
+	for _, text := range []string{"one\ntw", "o\r\n", "", "three\nfour\nfi", "ve"} {
+		n, err := w.Write([]byte(text))
+		if err != nil || n != len(text) {
+			t.Fatal(n, err)
+		}
+	}
+	if strings.Join(lines, "|") != "one|two|three|four" {
+		t.Fatal(lines)
+	}
+	w.Flush()
+	if strings.Join(lines, "|") != "one|two|three|four|five" {
+		t.Fatal(lines)
+	}
+	// Nothing left to flush.
+	w.Flush()
+	if len(lines) != 5 {
+		t.Fatal(lines)
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &tcpProbe{addr: listener.Addr().String()}
+	if !p.Ready() {
+		t.Fatal("not ready while listening")
+	}
+	listener.Close()
+	if p.Ready() {
+		t.Fatal("ready after close")
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	p := &httpProbe{url: server.URL}
+	if p.Ready() {
+		t.Fatal("ready on an error status")
+	}
+	status = http.StatusNoContent
+	if !p.Ready() {
+		t.Fatal("not ready on success")
+	}
+	server.Close()
+	if p.Ready() {
+		t.Fatal("ready after close")
+	}
+}
+
+func TestLogProbe(t *testing.T) {
+	p := &logProbe{pattern: regexp.MustCompile(`listening on \d+`)}
+	w := &lineWriter{line: p.Line}
+	w.Write([]byte("starting\nlistening on "))
+	if p.Ready() {
+		t.Fatal("ready on a partial line")
+	}
+	w.Write([]byte("80"))
+	w.Flush()
+	if !p.Ready() {
+		t.Fatal("not ready after the final line")
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	p, err := startProcess(exec.Command("sleep", "10"), func(p *process) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.stop(syscall.SIGKILL, time.Second, io.Discard)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	err = waitReady(p, []probe{&tcpProbe{addr: listener.Addr().String()}}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	never := &logProbe{pattern: regexp.MustCompile("never")}
+	err = waitReady(p, []probe{never}, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "waiting on log never") {
+		t.Fatal(err)
+	}
+
+	exited, err := startProcess(exec.Command("false"), func(p *process) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitReady(exited, []probe{never}, 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "exited before ready") {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package coverage
+
+import (
+	"bytes"
+	"github.com/ncbray/crank/task"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const profileText = `mode: set
+example.com/foo/a.go:3.14,5.2 2 1
+example.com/foo/a.go:7.14,9.2 2 0
+example.com/foo/bar/b.go:3.14,4.2 1 1
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader(profileText))
+	assert.NoError(t, err)
+	assert.Equal(t, "set", p.Mode)
+	assert.Equal(t, 3, len(p.Blocks))
+	assert.Equal(t, &Block{File: "example.com/foo/a.go", StartLine: 7, StartCol: 14, EndLine: 9, EndCol: 2, NumStmt: 2, Count: 0}, p.Blocks[1])
+
+	var buffer bytes.Buffer
+	assert.NoError(t, p.Write(&buffer))
+	assert.Equal(t, profileText, buffer.String())
+
+	_, err = Parse(strings.NewReader("mode: set\nnonsense\n"))
+	assert.Error(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	a, _ := Parse(strings.NewReader(profileText))
+	b, _ := Parse(strings.NewReader("mode: set\nexample.com/foo/a.go:7.14,9.2 2 1\nexample.com/foo/a.go:3.14,5.2 2 0\n"))
+	merged := Merge(a, b)
+	assert.Equal(t, 3, len(merged.Blocks))
+	for _, block := range merged.Blocks {
+		assert.Equal(t, 1, block.Count)
+	}
+	// The inputs are left alone.
+	assert.Equal(t, 0, a.Blocks[1].Count)
+
+	a.Mode = "count"
+	b.Mode = "count"
+	merged = Merge(a, b)
+	assert.Equal(t, 1, merged.Blocks[0].Count)
+	a.Blocks[0].Count = 3
+	merged = Merge(a, a)
+	assert.Equal(t, 6, merged.Blocks[0].Count)
+}
+
+func TestSummarize(t *testing.T) {
+	p, _ := Parse(strings.NewReader(profileText))
+	s := Summarize(p)
+	assert.Equal(t, []string{"example.com/foo", "example.com/foo/bar"}, s.PackageNames())
+	assert.Equal(t, 50.0, s.Packages["example.com/foo"].Percent())
+	assert.Equal(t, 100.0, s.Packages["example.com/foo/bar"].Percent())
+	assert.Equal(t, Counts{Statements: 5, Covered: 3}, s.Total)
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	assert.NoError(t, s.Save(path))
+	loaded, err := LoadSummary(path)
+	assert.NoError(t, err)
+	assert.Equal(t, s, loaded)
+}
+
+func TestCommand(t *testing.T) {
+	ct := &Task{Test: task.Command("go", "test", "foo/..."), Dir: "out", CoverPkg: "foo/..."}
+	assert.Equal(t, []string{"go", "test", "-coverprofile=" + filepath.Join("out", "coverage.out"), "-coverpkg=foo/...", "foo/..."}, ct.Command().Args)
+}
+
+func TestHandler(t *testing.T) {
+	ct := &Task{Dir: t.TempDir()}
+	rec := httptest.NewRecorder()
+	ct.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "No coverage yet")
+
+	p, _ := Parse(strings.NewReader(profileText))
+	assert.NoError(t, Summarize(p).Save(ct.SummaryPath()))
+	rec = httptest.NewRecorder()
+	ct.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, rec.Body.String(), "example.com/foo/bar")
+	assert.Contains(t, rec.Body.String(), "60.0%")
+
+	rec = httptest.NewRecorder()
+	ct.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	assert.Equal(t, 404, rec.Code)
+}
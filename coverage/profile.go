@@ -0,0 +1,138 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Block is one line of a cover profile: a range of statements in a file
+// and how often it ran.
+type Block struct {
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int
+}
+
+func (b *Block) key() string {
+	return fmt.Sprintf("%s:%d.%d,%d.%d", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+}
+
+type Profile struct {
+	// "set", "count", or "atomic".
+	Mode   string
+	Blocks []*Block
+}
+
+// Parse a profile written by go test -coverprofile.
+func Parse(r io.Reader) (*Profile, error) {
+	p := &Profile{}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode: ") {
+			p.Mode = strings.TrimPrefix(line, "mode: ")
+			continue
+		}
+		b, err := parseBlock(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+		p.Blocks = append(p.Blocks, b)
+	}
+	return p, scanner.Err()
+}
+
+// file:startLine.startCol,endLine.endCol numStmt count
+func parseBlock(line string) (*Block, error) {
+	colon := strings.LastIndex(line, ":")
+	if colon < 0 {
+		return nil, fmt.Errorf("expected file:range, got %#v", line)
+	}
+	b := &Block{File: line[:colon]}
+	_, err := fmt.Sscanf(line[colon+1:], "%d.%d,%d.%d %d %d", &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol, &b.NumStmt, &b.Count)
+	if err != nil {
+		return nil, fmt.Errorf("bad block %#v: %s", line, err)
+	}
+	return b, nil
+}
+
+func ParseFile(path string) (*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Merge profiles, such as those of separate packages or test runs.  Blocks
+// seen in several profiles have their counts added, or in set mode are
+// covered if any profile covered them.
+func Merge(profiles ...*Profile) *Profile {
+	merged := &Profile{}
+	lookup := map[string]*Block{}
+	for _, p := range profiles {
+		if merged.Mode == "" {
+			merged.Mode = p.Mode
+		}
+		for _, b := range p.Blocks {
+			existing := lookup[b.key()]
+			if existing == nil {
+				copied := *b
+				lookup[b.key()] = &copied
+				merged.Blocks = append(merged.Blocks, &copied)
+				continue
+			}
+			if merged.Mode == "set" {
+				if b.Count > existing.Count {
+					existing.Count = b.Count
+				}
+			} else {
+				existing.Count += b.Count
+			}
+		}
+	}
+	sort.SliceStable(merged.Blocks, func(i, j int) bool {
+		a, b := merged.Blocks[i], merged.Blocks[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.StartCol < b.StartCol
+	})
+	return merged
+}
+
+func (p *Profile) Write(w io.Writer) error {
+	mode := p.Mode
+	if mode == "" {
+		mode = "set"
+	}
+	_, err := fmt.Fprintf(w, "mode: %s\n", mode)
+	if err != nil {
+		return err
+	}
+	for _, b := range p.Blocks {
+		_, err = io.WriteString(w, b.key()+" "+strconv.Itoa(b.NumStmt)+" "+strconv.Itoa(b.Count)+"\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var summaryTemplate = template.Must(template.New("summary").Funcs(template.FuncMap{
+	"percent": func(c *Counts) string { return formatPercent(c.Percent(), false) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Coverage</title></head>
+<body>
+<h1>Coverage</h1>
+{{if .}}
+<table>
+<tr><th>Package</th><th>Statements</th><th>Coverage</th></tr>
+{{range $name, $counts := .Packages}}<tr><td>{{$name}}</td><td>{{$counts.Statements}}</td><td>{{percent $counts}}</td></tr>
+{{end}}<tr><th>Total</th><th>{{.Total.Statements}}</th><th>{{percent .Total}}</th></tr>
+</table>
+<p><a href="/html">Annotated source</a></p>
+{{else}}
+<p>No coverage yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// Handler serves the summary of a Task's latest run at "/", and the
+// annotated source from go tool cover at "/html".
+func (t *Task) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		s, err := LoadSummary(t.SummaryPath())
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = summaryTemplate.Execute(w, s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		out := filepath.Join(t.Dir, "coverage.html")
+		output, err := exec.Command("go", "tool", "cover", "-html="+t.ProfilePath(), "-o", out).CombinedOutput()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s\n%s", err, output), http.StatusInternalServerError)
+			return
+		}
+		http.ServeFile(w, r, out)
+	})
+	return mux
+}
@@ -0,0 +1,84 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+type Counts struct {
+	Statements int
+	Covered    int
+}
+
+func (c *Counts) Percent() float64 {
+	if c.Statements == 0 {
+		return 100
+	}
+	return 100 * float64(c.Covered) / float64(c.Statements)
+}
+
+func formatPercent(value float64, sign bool) string {
+	if sign {
+		return fmt.Sprintf("%+.1f%%", value)
+	}
+	return fmt.Sprintf("%.1f%%", value)
+}
+
+// Summary is the statement coverage of each package.
+type Summary struct {
+	Packages map[string]*Counts
+	Total    Counts
+}
+
+func Summarize(p *Profile) *Summary {
+	s := &Summary{Packages: map[string]*Counts{}}
+	for _, b := range p.Blocks {
+		pkg := path.Dir(b.File)
+		counts := s.Packages[pkg]
+		if counts == nil {
+			counts = &Counts{}
+			s.Packages[pkg] = counts
+		}
+		counts.Statements += b.NumStmt
+		s.Total.Statements += b.NumStmt
+		if b.Count > 0 {
+			counts.Covered += b.NumStmt
+			s.Total.Covered += b.NumStmt
+		}
+	}
+	return s
+}
+
+func (s *Summary) PackageNames() []string {
+	names := []string{}
+	for name := range s.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LoadSummary(path string) (*Summary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Summary{}
+	return s, json.Unmarshal(data, s)
+}
+
+func (s *Summary) Save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
@@ -0,0 +1,113 @@
+package coverage
+
+import (
+	"github.com/ncbray/crank/task"
+	"os"
+	"path/filepath"
+)
+
+// Task runs go test with a cover profile and reports the coverage of each
+// package, compared to the previous run.
+type Task struct {
+	// The go test command, which the profile flags are added to.
+	Test *task.CommandTask
+	// Also count coverage of these packages from other packages' tests.
+	CoverPkg string
+	// Where the profile and summary are kept.
+	Dir string
+	// Fail when total coverage is below this percentage.
+	Min float64
+}
+
+func (t *Task) ProfilePath() string {
+	return filepath.Join(t.Dir, "coverage.out")
+}
+
+func (t *Task) SummaryPath() string {
+	return filepath.Join(t.Dir, "summary.json")
+}
+
+func (t *Task) Command() *task.CommandTask {
+	flags := []string{"-coverprofile=" + t.ProfilePath()}
+	if t.CoverPkg != "" {
+		flags = append(flags, "-coverpkg="+t.CoverPkg)
+	}
+	// Flags go right after "go test", before the packages.
+	args := append([]string{}, t.Test.Args[:2]...)
+	args = append(args, flags...)
+	args = append(args, t.Test.Args[2:]...)
+	return &task.CommandTask{Args: args, Env: t.Test.Env, Dir: t.Test.Dir}
+}
+
+func formatDelta(counts *Counts, previous *Counts) string {
+	if previous == nil {
+		return ""
+	}
+	delta := counts.Percent() - previous.Percent()
+	if delta > -0.05 && delta < 0.05 {
+		return ""
+	}
+	return " (" + formatPercent(delta, true) + ")"
+}
+
+func (t *Task) report(log task.TaskLog, s *Summary, previous *Summary) {
+	for _, name := range s.PackageNames() {
+		var old *Counts
+		if previous != nil {
+			old = previous.Packages[name]
+		}
+		counts := s.Packages[name]
+		log.LogInfo("%7s%s %s", formatPercent(counts.Percent(), false), formatDelta(counts, old), name)
+	}
+	var old *Counts
+	if previous != nil {
+		old = &previous.Total
+	}
+	log.LogInfo("%7s%s total", formatPercent(s.Total.Percent(), false), formatDelta(&s.Total, old))
+}
+
+func (t *Task) Run(log task.TaskLog) bool {
+	err := os.MkdirAll(t.Dir, 0777)
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+	// A stale profile would be reported as if it came from this run.
+	os.Remove(t.ProfilePath())
+	ok := t.Command().Run(log)
+
+	profile, err := ParseFile(t.ProfilePath())
+	if os.IsNotExist(err) {
+		// The tests did not get far enough to write one.
+		return false
+	}
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+	// With -coverpkg, packages show up once for every test binary.
+	profile = Merge(profile)
+	f, err := os.Create(t.ProfilePath())
+	if err == nil {
+		err = profile.Write(f)
+		f.Close()
+	}
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+
+	s := Summarize(profile)
+	previous, _ := LoadSummary(t.SummaryPath())
+	t.report(log, s, previous)
+	err = s.Save(t.SummaryPath())
+	if err != nil {
+		log.LogError("%s", err)
+		return false
+	}
+	if t.Min > 0 && s.Total.Percent() < t.Min {
+		log.LogError("Coverage %s is below the minimum of %s", formatPercent(s.Total.Percent(), false), formatPercent(t.Min, false))
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,140 @@
+// Package git asks the git command line about a working tree.
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func lines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// The absolute path of the .git directory for the tree containing dir.
+func GitDir(dir string) (string, error) {
+	return run(dir, "rev-parse", "--absolute-git-dir")
+}
+
+func TopLevel(dir string) (string, error) {
+	return run(dir, "rev-parse", "--show-toplevel")
+}
+
+// Run a query that fails quietly when there is no answer, telling that
+// apart from not being in a repository at all.
+func query(dir string, args ...string) (string, error) {
+	out, err := run(dir, args...)
+	if err != nil {
+		_, repoErr := GitDir(dir)
+		if repoErr != nil {
+			return "", repoErr
+		}
+		return "", nil
+	}
+	return out, nil
+}
+
+// The SHA of the commit checked out, or "" in a repository without commits.
+func Head(dir string) (string, error) {
+	return query(dir, "rev-parse", "-q", "--verify", "HEAD")
+}
+
+// The name of the branch checked out, or "" when HEAD is detached.
+func Branch(dir string) (string, error) {
+	return query(dir, "symbolic-ref", "-q", "--short", "HEAD")
+}
+
+func ResolveRef(dir string, ref string) (string, error) {
+	return run(dir, "rev-parse", "--verify", ref+"^{commit}")
+}
+
+// Files under dir that differ from base, including staged, unstaged and
+// untracked files.  Paths are relative to dir.
+func ChangedFiles(dir string, base string) ([]string, error) {
+	diff, err := run(dir, "diff", "--name-only", "--relative", base)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := run(dir, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	return append(lines(diff), lines(untracked)...), nil
+}
+
+func Short(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// Where git looks for hooks, which core.hooksPath can move.
+func HooksDir(dir string) (string, error) {
+	path, err := run(dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Abs(path)
+}
+
+// Commits after good up to and including bad, oldest first, that descend
+// from good.  Only first parents are followed, so the commits form a line
+// with merges standing in for the branches they bring in.
+func AncestryPath(dir string, good string, bad string) ([]string, error) {
+	out, err := run(dir, "rev-list", "--reverse", "--first-parent", "--ancestry-path", good+".."+bad)
+	if err != nil {
+		return nil, err
+	}
+	return lines(out), nil
+}
+
+func Subject(dir string, sha string) (string, error) {
+	return run(dir, "log", "-1", "--format=%s", sha)
+}
+
+// Check out a commit in a new worktree at path, without a branch.
+func AddWorktree(dir string, path string, sha string) error {
+	_, err := run(dir, "worktree", "add", "-q", "--detach", path, sha)
+	return err
+}
+
+func RemoveWorktree(dir string, path string) error {
+	_, err := run(dir, "worktree", "remove", "--force", path)
+	return err
+}
+
+// Copy the files in the index, as they are staged, to path.
+func CheckoutIndex(dir string, path string) error {
+	top, err := TopLevel(dir)
+	if err != nil {
+		return err
+	}
+	_, err = run(top, "checkout-index", "-a", "--prefix="+path+string(filepath.Separator))
+	return err
+}
+
+func Checkout(dir string, sha string) error {
+	_, err := run(dir, "checkout", "-q", "--force", "--detach", sha)
+	return err
+}
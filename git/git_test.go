@@ -0,0 +1,179 @@
+package git
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func gitCmd(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal(string(out), err)
+	}
+}
+
+func makeRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	gitCmd(t, dir, "init", "-q", "-b", "main")
+	return dir
+}
+
+func TestHeadAndBranch(t *testing.T) {
+	dir := makeRepo(t)
+
+	sha, err := Head(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "", sha)
+
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0666)
+	gitCmd(t, dir, "add", "a.go")
+	gitCmd(t, dir, "commit", "-q", "-m", "first")
+
+	sha, err = Head(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 40, len(sha))
+	assert.Equal(t, sha[:7], Short(sha))
+
+	resolved, err := ResolveRef(dir, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, sha, resolved)
+
+	branch, err := Branch(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", branch)
+
+	gitCmd(t, dir, "checkout", "-q", "--detach")
+	branch, err = Branch(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "", branch)
+
+	gitDir, err := GitDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, ".git", filepath.Base(gitDir))
+
+	hooks, err := HooksDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(gitDir, "hooks"), hooks)
+
+	_, err = Head(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir := makeRepo(t)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0777)
+	for _, name := range []string{"a.go", "b.go", "sub/c.go"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("package a\n"), 0666)
+	}
+	gitCmd(t, dir, "add", ".")
+	gitCmd(t, dir, "commit", "-q", "-m", "first")
+
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a // staged\n"), 0666)
+	gitCmd(t, dir, "add", "a.go")
+	os.WriteFile(filepath.Join(dir, "sub/c.go"), []byte("package a // unstaged\n"), 0666)
+	os.WriteFile(filepath.Join(dir, "sub/d.go"), []byte("package a\n"), 0666)
+
+	files, err := ChangedFiles(dir, "HEAD")
+	assert.NoError(t, err)
+	sort.Strings(files)
+	assert.Equal(t, []string{"a.go", "sub/c.go", "sub/d.go"}, files)
+
+	files, err = ChangedFiles(filepath.Join(dir, "sub"), "HEAD")
+	assert.NoError(t, err)
+	sort.Strings(files)
+	assert.Equal(t, []string{"c.go", "d.go"}, files)
+}
+
+func TestWorktree(t *testing.T) {
+	dir := makeRepo(t)
+	shas := []string{}
+	for _, text := range []string{"one", "two", "three"} {
+		os.WriteFile(filepath.Join(dir, "a.txt"), []byte(text), 0666)
+		gitCmd(t, dir, "add", "a.txt")
+		gitCmd(t, dir, "commit", "-q", "-m", text)
+		sha, err := Head(dir)
+		assert.NoError(t, err)
+		shas = append(shas, sha)
+	}
+
+	path, err := AncestryPath(dir, shas[0], "main")
+	assert.NoError(t, err)
+	assert.Equal(t, shas[1:], path)
+
+	subject, err := Subject(dir, shas[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "two", subject)
+
+	tree := filepath.Join(t.TempDir(), "tree")
+	assert.NoError(t, AddWorktree(dir, tree, shas[0]))
+	data, _ := os.ReadFile(filepath.Join(tree, "a.txt"))
+	assert.Equal(t, "one", string(data))
+
+	assert.NoError(t, Checkout(tree, shas[1]))
+	data, _ = os.ReadFile(filepath.Join(tree, "a.txt"))
+	assert.Equal(t, "two", string(data))
+
+	assert.NoError(t, RemoveWorktree(dir, tree))
+	_, err = os.Stat(tree)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAncestryPathMerge(t *testing.T) {
+	dir := makeRepo(t)
+	commit := func(name string) string {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0666)
+		gitCmd(t, dir, "add", name)
+		gitCmd(t, dir, "commit", "-q", "-m", name)
+		sha, err := Head(dir)
+		assert.NoError(t, err)
+		return sha
+	}
+	good := commit("one")
+	gitCmd(t, dir, "checkout", "-q", "-b", "side")
+	side := commit("side")
+	gitCmd(t, dir, "checkout", "-q", "main")
+	main := commit("main")
+	gitCmd(t, dir, "merge", "-q", "--no-edit", "side")
+	merge, err := Head(dir)
+	assert.NoError(t, err)
+
+	// The side branch is represented by the merge.
+	path, err := AncestryPath(dir, good, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{main, merge}, path)
+
+	// From the side branch, the merge is the only step.
+	path, err = AncestryPath(dir, side, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{merge}, path)
+}
+
+func TestCheckoutIndex(t *testing.T) {
+	dir := makeRepo(t)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0777))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.go"), []byte("staged"), 0666))
+	gitCmd(t, dir, "add", "sub/a.go")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.go"), []byte("unstaged"), 0666))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "untracked.go"), []byte("untracked"), 0666))
+
+	out := filepath.Join(t.TempDir(), "snapshot")
+	assert.NoError(t, CheckoutIndex(filepath.Join(dir, "sub"), out))
+	data, err := os.ReadFile(filepath.Join(out, "sub", "a.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, "staged", string(data))
+	_, err = os.Stat(filepath.Join(out, "untracked.go"))
+	assert.True(t, os.IsNotExist(err))
+}
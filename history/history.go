@@ -0,0 +1,294 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Results a node can have.
+const (
+	Success = "success"
+	Error   = "error"
+	Flaky   = "flaky"
+)
+
+type NodeRecord struct {
+	Task     string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	Result   string
+	// Replayed from the action cache, so the duration says little.
+	Cached bool `json:",omitempty"`
+	// Where the node's output was saved, relative to the store.
+	Log string `json:",omitempty"`
+}
+
+type RunRecord struct {
+	ID    string
+	Start time.Time
+	End   time.Time
+	// The changed files that caused the run, empty for the first run.
+	Trigger []string `json:",omitempty"`
+	// What was checked out, if known.
+	Commit string `json:",omitempty"`
+	Branch string `json:",omitempty"`
+	Nodes  []*NodeRecord
+}
+
+func (r *RunRecord) Node(task string) *NodeRecord {
+	for _, node := range r.Nodes {
+		if node.Task == task {
+			return node
+		}
+	}
+	return nil
+}
+
+// Defaults for how much history a Store keeps.
+const (
+	DefaultMaxRuns = 500
+	DefaultMaxAge  = 30 * 24 * time.Hour
+)
+
+// Store appends runs to a file of JSON lines and keeps logs alongside it.
+// Runs beyond MaxRuns, or MaxAge older than the latest run, are forgotten
+// along with their logs.  Zero means the default, negative means no limit.
+type Store struct {
+	Dir     string
+	MaxRuns int
+	MaxAge  time.Duration
+}
+
+func (s *Store) maxRuns() int {
+	if s.MaxRuns == 0 {
+		return DefaultMaxRuns
+	}
+	return s.MaxRuns
+}
+
+func (s *Store) maxAge() time.Duration {
+	if s.MaxAge == 0 {
+		return DefaultMaxAge
+	}
+	return s.MaxAge
+}
+
+// The runs to keep, from all runs oldest first.
+func (s *Store) retained(runs []*RunRecord) []*RunRecord {
+	if max := s.maxRuns(); max > 0 && len(runs) > max {
+		runs = runs[len(runs)-max:]
+	}
+	if age := s.maxAge(); age > 0 && len(runs) > 0 {
+		cutoff := runs[len(runs)-1].Start.Add(-age)
+		for len(runs) > 0 && runs[0].Start.Before(cutoff) {
+			runs = runs[1:]
+		}
+	}
+	return runs
+}
+
+// IDs sort in the order runs started.
+func MakeID(start time.Time) string {
+	return start.UTC().Format("20060102-150405.000")
+}
+
+func (s *Store) runsPath() string {
+	return filepath.Join(s.Dir, "history.jsonl")
+}
+
+// Append a run, then prune the history.
+func (s *Store) Append(run *RunRecord) error {
+	err := os.MkdirAll(s.Dir, 0777)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.runsPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return s.Prune()
+}
+
+// Prune rewrites the history without the runs past the limits, and deletes
+// logs that no kept run refers to.
+func (s *Store) Prune() error {
+	all, err := s.readRuns()
+	if err != nil {
+		return err
+	}
+	runs := s.retained(all)
+	if len(runs) < len(all) {
+		err = s.rewrite(runs)
+		if err != nil {
+			return err
+		}
+	}
+
+	kept := map[string]bool{}
+	for _, run := range runs {
+		kept[run.ID] = true
+	}
+	logs := filepath.Join(s.Dir, "logs")
+	entries, err := os.ReadDir(logs)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !kept[entry.Name()] {
+			err = os.RemoveAll(filepath.Join(logs, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Replace the history file, atomically so a crash cannot lose it.
+func (s *Store) rewrite(runs []*RunRecord) error {
+	f, err := os.CreateTemp(s.Dir, "history-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for _, run := range runs {
+		data, err := json.Marshal(run)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(f.Name(), s.runsPath())
+}
+
+// Save a node's output, returning the path to put in its NodeRecord.
+func (s *Store) WriteLog(runID string, task string, data []byte) (string, error) {
+	rel := filepath.Join("logs", runID, strings.Replace(task, "/", "_", -1)+".log")
+	path := filepath.Join(s.Dir, rel)
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return "", err
+	}
+	return rel, os.WriteFile(path, data, 0666)
+}
+
+func (s *Store) ReadLog(node *NodeRecord) ([]byte, error) {
+	if node.Log == "" {
+		return nil, fmt.Errorf("no log for %s", node.Task)
+	}
+	return os.ReadFile(filepath.Join(s.Dir, node.Log))
+}
+
+// The runs within the limits, oldest first.
+func (s *Store) Runs() ([]*RunRecord, error) {
+	runs, err := s.readRuns()
+	if err != nil {
+		return nil, err
+	}
+	return s.retained(runs), nil
+}
+
+func (s *Store) readRuns() ([]*RunRecord, error) {
+	f, err := os.Open(s.runsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	runs := []*RunRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		run := &RunRecord{}
+		// A torn line from a crash should not lose the rest of the history.
+		if json.Unmarshal(scanner.Bytes(), run) != nil {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, scanner.Err()
+}
+
+func (s *Store) Run(id string) (*RunRecord, error) {
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if run.ID == id {
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("no run %#v", id)
+}
+
+// Filter selects runs with a node matching both the task and result, where
+// empty matches anything.
+type Filter struct {
+	Task   string
+	Result string
+	// Zero means no limit.
+	Limit int
+}
+
+func (f *Filter) matches(run *RunRecord) bool {
+	for _, node := range run.Nodes {
+		if (f.Task == "" || node.Task == f.Task) && (f.Result == "" || node.Result == f.Result) {
+			return true
+		}
+	}
+	return f.Task == "" && f.Result == ""
+}
+
+// Matching runs, newest first.
+func (s *Store) Query(f *Filter) ([]*RunRecord, error) {
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	matched := []*RunRecord{}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(matched) >= f.Limit {
+			break
+		}
+		if f.matches(runs[i]) {
+			matched = append(matched, runs[i])
+		}
+	}
+	return matched, nil
+}
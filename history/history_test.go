@@ -0,0 +1,134 @@
+package history
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeRun(start time.Time, results ...string) *RunRecord {
+	run := &RunRecord{ID: MakeID(start), Start: start, End: start.Add(time.Second)}
+	tasks := []string{"vet", "test", "install"}
+	for i, result := range results {
+		run.Nodes = append(run.Nodes, &NodeRecord{Task: tasks[i], Result: result})
+	}
+	return run
+}
+
+func TestAppendQuery(t *testing.T) {
+	s := &Store{Dir: filepath.Join(t.TempDir(), ".crank")}
+
+	runs, err := s.Runs()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(runs))
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, s.Append(makeRun(start, Success, Success, Success)))
+	assert.NoError(t, s.Append(makeRun(start.Add(time.Minute), Success, Error)))
+	assert.NoError(t, s.Append(makeRun(start.Add(2*time.Minute), Success, Flaky, Success)))
+
+	runs, err = s.Query(&Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(runs))
+	assert.Equal(t, "20200102-030605.000", runs[0].ID)
+
+	runs, err = s.Query(&Filter{Result: Error})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, Error, runs[0].Node("test").Result)
+
+	runs, err = s.Query(&Filter{Task: "install"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(runs))
+
+	runs, err = s.Query(&Filter{Task: "vet", Result: Error})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(runs))
+
+	runs, err = s.Query(&Filter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(runs))
+
+	run, err := s.Run("20200102-030405.000")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(run.Nodes))
+}
+
+func TestLogs(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	path, err := s.WriteLog("run", "test", []byte("FAIL\n"))
+	assert.NoError(t, err)
+
+	data, err := s.ReadLog(&NodeRecord{Task: "test", Log: path})
+	assert.NoError(t, err)
+	assert.Equal(t, "FAIL\n", string(data))
+
+	_, err = s.ReadLog(&NodeRecord{Task: "vet"})
+	assert.Error(t, err)
+}
+
+func TestTornLine(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	assert.NoError(t, s.Append(makeRun(time.Now(), Success)))
+	f, err := os.OpenFile(s.runsPath(), os.O_WRONLY|os.O_APPEND, 0666)
+	assert.NoError(t, err)
+	f.WriteString("{\"ID\": \"tor\n")
+	f.Close()
+	assert.NoError(t, s.Append(makeRun(time.Now(), Error)))
+
+	runs, err := s.Runs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(runs))
+}
+
+func TestRetention(t *testing.T) {
+	s := &Store{Dir: filepath.Join(t.TempDir(), ".crank"), MaxRuns: 3, MaxAge: time.Hour}
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ids := []string{}
+	for i := 0; i < 5; i++ {
+		run := makeRun(start.Add(time.Duration(i)*time.Minute), Success)
+		_, err := s.WriteLog(run.ID, "vet", []byte("output"))
+		assert.NoError(t, err)
+		assert.NoError(t, s.Append(run))
+		ids = append(ids, run.ID)
+	}
+
+	runs, err := s.readRuns()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(runs))
+	assert.Equal(t, ids[2], runs[0].ID)
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "logs"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, ids[2], entries[0].Name())
+
+	// Much later, only the latest run is recent enough.
+	run := makeRun(start.Add(2*time.Hour), Success)
+	assert.NoError(t, s.Append(run))
+	runs, err = s.Query(&Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, run.ID, runs[0].ID)
+	entries, err = os.ReadDir(filepath.Join(s.Dir, "logs"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestRetentionOnRead(t *testing.T) {
+	s := &Store{Dir: filepath.Join(t.TempDir(), ".crank"), MaxRuns: -1}
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, s.Append(makeRun(start.Add(time.Duration(i)*time.Minute), Success)))
+	}
+
+	// A reader with a smaller limit only sees the latest runs.
+	limited := &Store{Dir: s.Dir, MaxRuns: 2}
+	runs, err := limited.Runs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(runs))
+	runs, err = s.Runs()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(runs))
+}
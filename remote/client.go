@@ -0,0 +1,197 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ncbray/crank/task"
+	"io"
+	"net"
+	"strings"
+)
+
+// Conn is a connection to a worker, redialed if it breaks.
+type Conn struct {
+	Network string
+	Addr    string
+	Token   string
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+func Dial(addr string, token string) (*Conn, error) {
+	network, address := ParseAddr(addr)
+	c := &Conn{Network: network, Addr: address, Token: token}
+	err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) connect() error {
+	conn, err := net.Dial(c.Network, c.Addr)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+	err = encoder.Encode(&Hello{Token: c.Token})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	event := &Event{}
+	err = decoder.Decode(event)
+	if err == nil && (event.Kind != EventDone || !event.OK) {
+		err = errors.New("worker refused the connection: " + event.Text)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c.conn = conn
+	c.encoder = encoder
+	c.decoder = decoder
+	return nil
+}
+
+func (c *Conn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Replays worker events into a local TaskLog.
+type replay struct {
+	root      task.TaskLog
+	subtasks  map[string]task.TaskLog
+	capturing map[task.TaskLog][2]io.Writer
+}
+
+func (r *replay) log(path []string) task.TaskLog {
+	if len(path) == 0 {
+		return r.root
+	}
+	key := strings.Join(path, "\x00")
+	log, ok := r.subtasks[key]
+	if !ok {
+		log = r.log(path[:len(path)-1]).CreateSubtask(path[len(path)-1])
+		r.subtasks[key] = log
+	}
+	return log
+}
+
+func (r *replay) writers(log task.TaskLog) [2]io.Writer {
+	w, ok := r.capturing[log]
+	if !ok {
+		w[0], w[1] = log.BeginCapture()
+		r.capturing[log] = w
+	}
+	return w
+}
+
+func (r *replay) event(event *Event) {
+	log := r.log(event.Path)
+	switch event.Kind {
+	case EventInfo:
+		log.LogInfo("%s", event.Text)
+	case EventError:
+		log.LogError("%s", event.Text)
+	case EventStdout:
+		r.writers(log)[0].Write([]byte(event.Text))
+	case EventStderr:
+		r.writers(log)[1].Write([]byte(event.Text))
+	case EventBegin:
+		log.Begin(event.Time)
+	case EventEnd:
+		log.End(event.Time, event.Duration)
+	}
+}
+
+func (r *replay) finish() {
+	for log := range r.capturing {
+		log.EndCapture()
+	}
+}
+
+// Run a request on the worker, replaying what it logs into log.
+func (c *Conn) Run(request *Request, log task.TaskLog) (task.Outcome, error) {
+	if c.conn == nil {
+		err := c.connect()
+		if err != nil {
+			return task.Interrupted, err
+		}
+	}
+	err := c.encoder.Encode(request)
+	if err != nil {
+		c.Close()
+		return task.Interrupted, err
+	}
+	r := &replay{root: log, subtasks: map[string]task.TaskLog{}, capturing: map[task.TaskLog][2]io.Writer{}}
+	defer r.finish()
+	for {
+		event := &Event{}
+		err := c.decoder.Decode(event)
+		if err != nil {
+			c.Close()
+			return task.Interrupted, err
+		}
+		if event.Kind == EventDone {
+			switch {
+			case event.OK:
+				return task.Passed, nil
+			case event.Failed:
+				return task.Failed, nil
+			}
+			return task.Interrupted, nil
+		}
+		r.event(event)
+	}
+}
+
+// Pool hands out idle worker connections.
+type Pool struct {
+	idle chan *Conn
+	size int
+}
+
+func NewPool(conns ...*Conn) *Pool {
+	p := &Pool{idle: make(chan *Conn, len(conns)), size: len(conns)}
+	for _, c := range conns {
+		p.idle <- c
+	}
+	return p
+}
+
+// How many tasks can run at once.
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Task runs a command on the next idle worker in the pool.
+type Task struct {
+	Pool    *Pool
+	Command *task.CommandTask
+}
+
+func (t *Task) Run(log task.TaskLog) bool {
+	return t.Execute(log) == task.Passed
+}
+
+// A worker that breaks is an interruption, not a failure of the command.
+func (t *Task) Execute(log task.TaskLog) task.Outcome {
+	c := <-t.Pool.idle
+	defer func() {
+		t.Pool.idle <- c
+	}()
+	outcome, err := c.Run(&Request{Args: t.Command.Args, Env: t.Command.Env, Dir: t.Command.Dir}, log)
+	if err != nil {
+		log.LogError("Worker %s failed: %s", c.Addr, err)
+		return task.Interrupted
+	}
+	return outcome
+}
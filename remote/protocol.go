@@ -0,0 +1,71 @@
+package remote
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// The protocol is a stream of JSON values in each direction.  The client
+// first sends a Hello, which the worker answers with a "done" event, or an
+// "error" event before hanging up.  Then the client sends a Request, the
+// worker replies with Events, the last of which is a "done" event, and the
+// connection can be reused for another request.
+
+// Hello authenticates a connection.
+type Hello struct {
+	// Must match the worker's token, if it has one.
+	Token string
+}
+
+// TokenEnv names the environment variable used for the token when none is
+// given explicitly.
+const TokenEnv = "CRANK_WORKER_TOKEN"
+
+// Request asks a worker to run a command.
+type Request struct {
+	Args []string
+	Env  []string
+	// Relative to the worker's directory.
+	Dir string `json:",omitempty"`
+}
+
+// Kinds of Event.
+const (
+	EventInfo   = "info"
+	EventError  = "error"
+	EventStdout = "stdout"
+	EventStderr = "stderr"
+	EventBegin  = "begin"
+	EventEnd    = "end"
+	EventDone   = "done"
+)
+
+// Event mirrors a call on the worker's TaskLog.
+type Event struct {
+	Kind string
+	// The subtask the event belongs to, empty for the task itself.
+	Path     []string      `json:",omitempty"`
+	Text     string        `json:",omitempty"`
+	Time     time.Time     `json:",omitempty"`
+	Duration time.Duration `json:",omitempty"`
+	OK       bool          `json:",omitempty"`
+	// Set on a "done" event when the command ran and failed, rather than
+	// not running to completion.
+	Failed bool `json:",omitempty"`
+}
+
+// Could other machines connect to a listener on addr?
+func Exposed(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && !tcp.IP.IsLoopback()
+}
+
+// Split "unix:/path/to/socket" or "tcp:host:port" into a network and
+// address.  Without a prefix, the address is assumed to be TCP.
+func ParseAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	return "tcp", strings.TrimPrefix(addr, "tcp:")
+}
@@ -0,0 +1,207 @@
+package remote
+
+import (
+	"encoding/json"
+	"github.com/ncbray/crank/task"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testToken = "secret"
+
+func startWorker(t *testing.T, network string, addr string) string {
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go Serve(listener, testToken)
+	return network + ":" + listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *Conn {
+	c, err := Dial(addr, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"localhost:7000", "tcp", "localhost:7000"},
+		{"tcp:localhost:7000", "tcp", "localhost:7000"},
+		{"unix:/tmp/crank.sock", "unix", "/tmp/crank.sock"},
+	}
+	for _, c := range cases {
+		network, address := ParseAddr(c.addr)
+		if network != c.network || address != c.address {
+			t.Fatal(c.addr, network, address)
+		}
+	}
+}
+
+func TestRemoteCommand(t *testing.T) {
+	addr := startWorker(t, "tcp", "127.0.0.1:0")
+	pool := NewPool(dial(t, addr))
+
+	log := &task.CaptureLog{}
+	ok := (&Task{Pool: pool, Command: task.Command("sh", "-c", "echo out; echo err >&2")}).Run(log)
+	if !ok {
+		t.Fatal("command failed")
+	}
+	if log.Stdout.String() != "out\n" || log.Stderr.String() != "err\n" {
+		t.Fatal(log.Stdout.String(), log.Stderr.String())
+	}
+
+	// The connection is reused for the next request.
+	ok = (&Task{Pool: pool, Command: task.Command("false")}).Run(log)
+	if ok {
+		t.Fatal("false succeeded")
+	}
+}
+
+func TestRemoteEnv(t *testing.T) {
+	addr := startWorker(t, "unix", filepath.Join(t.TempDir(), "worker.sock"))
+	pool := NewPool(dial(t, addr))
+
+	log := &task.CaptureLog{}
+	command := &task.CommandTask{Args: []string{"sh", "-c", "echo $CRANK_TEST"}, Env: []string{"CRANK_TEST=remote"}}
+	ok := (&Task{Pool: pool, Command: command}).Run(log)
+	if !ok || log.Stdout.String() != "remote\n" {
+		t.Fatal(ok, log.Stdout.String())
+	}
+}
+
+func TestRemotePool(t *testing.T) {
+	addr := startWorker(t, "tcp", "127.0.0.1:0")
+	pool := NewPool(dial(t, addr), dial(t, addr))
+
+	wg := sync.WaitGroup{}
+	results := make([]bool, 6)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = (&Task{Pool: pool, Command: task.Command("true")}).Run(&task.NullLog{})
+		}(i)
+	}
+	wg.Wait()
+	for i, ok := range results {
+		if !ok {
+			t.Fatal(i)
+		}
+	}
+}
+
+func TestRemoteReconnect(t *testing.T) {
+	addr := startWorker(t, "tcp", "127.0.0.1:0")
+	c := dial(t, addr)
+	pool := NewPool(c)
+
+	// Break the connection, the next request should redial.
+	c.conn.Close()
+	outcome := (&Task{Pool: pool, Command: task.Command("true")}).Execute(&task.NullLog{})
+	if outcome != task.Interrupted {
+		t.Fatal("request on a closed connection", outcome)
+	}
+	ok := (&Task{Pool: pool, Command: task.Command("true")}).Run(&task.NullLog{})
+	if !ok {
+		t.Fatal("reconnect failed")
+	}
+}
+
+func TestRemoteOutcome(t *testing.T) {
+	addr := startWorker(t, "tcp", "127.0.0.1:0")
+	pool := NewPool(dial(t, addr))
+	cases := []struct {
+		args    []string
+		outcome task.Outcome
+	}{
+		{[]string{"true"}, task.Passed},
+		{[]string{"false"}, task.Failed},
+		{[]string{"no-such-command-crank"}, task.Interrupted},
+	}
+	for _, c := range cases {
+		outcome := (&Task{Pool: pool, Command: task.Command(c.args...)}).Execute(&task.NullLog{})
+		if outcome != c.outcome {
+			t.Fatal(c.args, outcome)
+		}
+	}
+}
+
+func TestRemoteBadToken(t *testing.T) {
+	addr := startWorker(t, "tcp", "127.0.0.1:0")
+	_, err := Dial(addr, "wrong")
+	if err == nil {
+		t.Fatal("wrong token accepted")
+	}
+	_, err = Dial(addr, "")
+	if err == nil {
+		t.Fatal("missing token accepted")
+	}
+}
+
+func TestRemoteUnauthenticated(t *testing.T) {
+	addr := startWorker(t, "tcp", "127.0.0.1:0")
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	// Skip the hello and go straight to a request.
+	network, address := ParseAddr(addr)
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	err = encoder.Encode(&Request{Args: []string{"touch", marker}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		event := &Event{}
+		err := decoder.Decode(event)
+		if err != nil {
+			break
+		}
+		if event.Kind == EventDone {
+			t.Fatal("request answered", event)
+		}
+	}
+	_, err = os.Stat(marker)
+	if !os.IsNotExist(err) {
+		t.Fatal("command ran", err)
+	}
+}
+
+func TestExposed(t *testing.T) {
+	cases := []struct {
+		addr    net.Addr
+		exposed bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::1")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("0.0.0.0")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}, true},
+		{&net.UnixAddr{Name: "/tmp/crank.sock", Net: "unix"}, false},
+	}
+	for _, c := range cases {
+		if Exposed(c.addr) != c.exposed {
+			t.Fatal(c.addr)
+		}
+	}
+}
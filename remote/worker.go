@@ -0,0 +1,111 @@
+package remote
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/ncbray/crank/task"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Sends events for everything logged, back to the client.
+type streamLog struct {
+	path    []string
+	mutex   *sync.Mutex
+	encoder *json.Encoder
+}
+
+func (log *streamLog) send(event *Event) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	event.Path = log.path
+	// Write errors show up when the next request is read.
+	log.encoder.Encode(event)
+}
+
+func (log *streamLog) LogInfo(format string, args ...interface{}) {
+	log.send(&Event{Kind: EventInfo, Text: fmt.Sprintf(format, args...)})
+}
+
+func (log *streamLog) LogError(format string, args ...interface{}) {
+	log.send(&Event{Kind: EventError, Text: fmt.Sprintf(format, args...)})
+}
+
+type streamWriter struct {
+	log  *streamLog
+	kind string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.log.send(&Event{Kind: w.kind, Text: string(p)})
+	return len(p), nil
+}
+
+func (log *streamLog) BeginCapture() (io.Writer, io.Writer) {
+	return &streamWriter{log: log, kind: EventStdout}, &streamWriter{log: log, kind: EventStderr}
+}
+
+func (log *streamLog) EndCapture() {
+}
+
+func (log *streamLog) CreateSubtask(name string) task.TaskLog {
+	path := make([]string, len(log.path), len(log.path)+1)
+	copy(path, log.path)
+	return &streamLog{path: append(path, name), mutex: log.mutex, encoder: log.encoder}
+}
+
+func (log *streamLog) Begin(t time.Time) {
+	log.send(&Event{Kind: EventBegin, Time: t})
+}
+
+func (log *streamLog) End(t time.Time, d time.Duration) {
+	log.send(&Event{Kind: EventEnd, Time: t, Duration: d})
+}
+
+// Serve runs requests from each connection until the listener is closed.
+// Commands run in the worker's working directory.  Connections must start
+// with a Hello carrying token, unless token is empty.
+func Serve(listener net.Listener, token string) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(conn, token)
+	}
+}
+
+func serveConn(conn net.Conn, token string) {
+	defer conn.Close()
+	decoder := json.NewDecoder(conn)
+	log := &streamLog{mutex: &sync.Mutex{}, encoder: json.NewEncoder(conn)}
+
+	hello := &Hello{}
+	err := decoder.Decode(hello)
+	if err != nil {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Token), []byte(token)) != 1 {
+		log.LogError("Bad worker token")
+		return
+	}
+	log.send(&Event{Kind: EventDone, OK: true})
+
+	for {
+		request := &Request{}
+		err := decoder.Decode(request)
+		if err != nil {
+			return
+		}
+		outcome := task.Interrupted
+		if len(request.Args) > 0 {
+			outcome = (&task.CommandTask{Args: request.Args, Env: request.Env, Dir: request.Dir}).Execute(log)
+		} else {
+			log.LogError("Empty command")
+		}
+		log.send(&Event{Kind: EventDone, OK: outcome == task.Passed, Failed: outcome == task.Failed})
+	}
+}
@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// Summary describes a series of durations, oldest first.
+type Summary struct {
+	Count int
+	Last  time.Duration
+	Mean  time.Duration
+	// Weighted towards recent durations, so it follows trends.
+	EWMA time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	Max  time.Duration
+}
+
+// How much each new duration counts towards the EWMA.
+const DefaultAlpha = 0.2
+
+func Summarize(durations []time.Duration) *Summary {
+	s := &Summary{Count: len(durations)}
+	if len(durations) == 0 {
+		return s
+	}
+	s.Last = durations[len(durations)-1]
+	s.Mean = Mean(durations)
+	s.EWMA = EWMA(durations, DefaultAlpha)
+	sorted := Sorted(durations)
+	s.P50 = Percentile(sorted, 50)
+	s.P90 = Percentile(sorted, 90)
+	s.Max = sorted[len(sorted)-1]
+	return s
+}
+
+func Mean(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return total / time.Duration(len(durations))
+}
+
+// Exponentially weighted moving average, starting from the oldest duration.
+func EWMA(durations []time.Duration, alpha float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	avg := float64(durations[0])
+	for _, d := range durations[1:] {
+		avg = alpha*float64(d) + (1-alpha)*avg
+	}
+	return time.Duration(avg)
+}
+
+func Sorted(durations []time.Duration) []time.Duration {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// The pth percentile of sorted durations, interpolating between neighbours.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := rank - float64(lower)
+	return sorted[lower] + time.Duration(frac*float64(sorted[lower+1]-sorted[lower]))
+}
+
+// SlowCheck decides when a duration is much slower than those before it.
+type SlowCheck struct {
+	// How many previous durations to compare against.
+	Window int
+	// Slow means slower than the median by this factor...
+	Factor float64
+	// ...and by at least this much, so tiny tasks do not trip it on noise.
+	MinDelta time.Duration
+	// Do not judge until there are enough previous durations.
+	MinSamples int
+}
+
+var DefaultSlowCheck = SlowCheck{
+	Window:     20,
+	Factor:     1.5,
+	MinDelta:   time.Second,
+	MinSamples: 5,
+}
+
+// Is latest slow compared to previous, oldest first?  Also returns the
+// median it was compared against.
+func (c *SlowCheck) Slow(previous []time.Duration, latest time.Duration) (bool, time.Duration) {
+	if len(previous) > c.Window {
+		previous = previous[len(previous)-c.Window:]
+	}
+	if len(previous) == 0 || len(previous) < c.MinSamples {
+		return false, 0
+	}
+	median := Percentile(Sorted(previous), 50)
+	slow := float64(latest) > c.Factor*float64(median) && latest-median >= c.MinDelta
+	return slow, median
+}
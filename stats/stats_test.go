@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func seconds(values ...float64) []time.Duration {
+	durations := []time.Duration{}
+	for _, v := range values {
+		durations = append(durations, time.Duration(v*float64(time.Second)))
+	}
+	return durations
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize(seconds(4, 1, 3, 2, 5))
+	assert.Equal(t, 5, s.Count)
+	assert.Equal(t, 5*time.Second, s.Last)
+	assert.Equal(t, 3*time.Second, s.Mean)
+	assert.Equal(t, 3*time.Second, s.P50)
+	assert.Equal(t, 4600*time.Millisecond, s.P90)
+	assert.Equal(t, 5*time.Second, s.Max)
+
+	s = Summarize(nil)
+	assert.Equal(t, 0, s.Count)
+	assert.Equal(t, time.Duration(0), s.Mean)
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := seconds(1, 2, 3, 4)
+	assert.Equal(t, time.Second, Percentile(sorted, 0))
+	assert.Equal(t, 2500*time.Millisecond, Percentile(sorted, 50))
+	assert.Equal(t, 4*time.Second, Percentile(sorted, 100))
+	assert.Equal(t, 7*time.Second, Percentile(seconds(7), 90))
+}
+
+func TestEWMA(t *testing.T) {
+	assert.Equal(t, 2*time.Second, EWMA(seconds(2, 2, 2), 0.5))
+	assert.Equal(t, 3*time.Second, EWMA(seconds(2, 4), 0.5))
+	// Recent durations count for more.
+	assert.True(t, EWMA(seconds(2, 1, 1, 5), 0.2) > EWMA(seconds(2, 5, 1, 1), 0.2))
+}
+
+func TestSlow(t *testing.T) {
+	c := DefaultSlowCheck
+	previous := seconds(4, 4.2, 3.9, 4.1, 4)
+
+	slow, median := c.Slow(previous, 9*time.Second)
+	assert.True(t, slow)
+	assert.Equal(t, 4*time.Second, median)
+
+	slow, _ = c.Slow(previous, 5*time.Second)
+	assert.False(t, slow)
+
+	// Not enough history.
+	slow, _ = c.Slow(previous[:3], 9*time.Second)
+	assert.False(t, slow)
+
+	// Tripled, but only by a few milliseconds.
+	slow, _ = c.Slow(seconds(0.01, 0.01, 0.01, 0.01, 0.01), 30*time.Millisecond)
+	assert.False(t, slow)
+
+	// Only the window is considered.
+	c.Window = 5
+	slow, _ = c.Slow(append(seconds(20, 20, 20, 20, 20, 20), previous...), 9*time.Second)
+	assert.True(t, slow)
+}
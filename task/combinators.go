@@ -0,0 +1,124 @@
+package task
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Run a task as a named subtask, timing it.
+func runSubtask(log TaskLog, name string, task TaskDecl) bool {
+	return executeSubtask(log, name, task) == Passed
+}
+
+func executeSubtask(log TaskLog, name string, task TaskDecl) Outcome {
+	sublog := log.CreateSubtask(name)
+	start := time.Now()
+	sublog.Begin(start)
+	outcome := Execute(task, sublog)
+	end := time.Now()
+	sublog.End(end, end.Sub(start))
+	return outcome
+}
+
+// SequenceTask runs tasks in order, stopping at the first failure.
+type SequenceTask struct {
+	Tasks []TaskDecl
+}
+
+func (task *SequenceTask) Run(log TaskLog) bool {
+	for i, child := range task.Tasks {
+		if !runSubtask(log, strconv.Itoa(i), child) {
+			return false
+		}
+	}
+	return true
+}
+
+func Sequence(tasks ...TaskDecl) *SequenceTask {
+	return &SequenceTask{Tasks: tasks}
+}
+
+// AllOfTask runs every task in order and succeeds if they all succeed.
+type AllOfTask struct {
+	Tasks []TaskDecl
+}
+
+func (task *AllOfTask) Run(log TaskLog) bool {
+	ok := true
+	for i, child := range task.Tasks {
+		if !runSubtask(log, strconv.Itoa(i), child) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func AllOf(tasks ...TaskDecl) *AllOfTask {
+	return &AllOfTask{Tasks: tasks}
+}
+
+// AnyOfTask runs every task in order and succeeds if any succeed.
+type AnyOfTask struct {
+	Tasks []TaskDecl
+}
+
+func (task *AnyOfTask) Run(log TaskLog) bool {
+	ok := false
+	for i, child := range task.Tasks {
+		if runSubtask(log, strconv.Itoa(i), child) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func AnyOf(tasks ...TaskDecl) *AnyOfTask {
+	return &AnyOfTask{Tasks: tasks}
+}
+
+// FallbackTask runs tasks in order until one succeeds.
+type FallbackTask struct {
+	Tasks []TaskDecl
+}
+
+func (task *FallbackTask) Run(log TaskLog) bool {
+	for i, child := range task.Tasks {
+		if runSubtask(log, strconv.Itoa(i), child) {
+			return true
+		}
+	}
+	return false
+}
+
+func Fallback(tasks ...TaskDecl) *FallbackTask {
+	return &FallbackTask{Tasks: tasks}
+}
+
+// ParallelTask runs every task at once and succeeds if they all succeed.
+type ParallelTask struct {
+	Tasks []TaskDecl
+}
+
+func (task *ParallelTask) Run(log TaskLog) bool {
+	results := make([]bool, len(task.Tasks))
+	wg := sync.WaitGroup{}
+	for i, child := range task.Tasks {
+		wg.Add(1)
+		go func(i int, child TaskDecl) {
+			defer wg.Done()
+			results[i] = runSubtask(log, strconv.Itoa(i), child)
+		}(i, child)
+	}
+	wg.Wait()
+	for _, ok := range results {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func Parallel(tasks ...TaskDecl) *ParallelTask {
+	return &ParallelTask{Tasks: tasks}
+}
@@ -1,30 +1,77 @@
 package task
 
 import (
+	"os"
 	"os/exec"
 	"strings"
 )
 
 func RunCommand(args []string, log TaskLog) bool {
-	log.LogInfo("Running: %s", strings.Join(args, " "))
+	return (&CommandTask{Args: args}).Run(log)
+}
+
+type CommandTask struct {
+	Args []string
+	// Extra KEY=VALUE entries, overriding the inherited environment.
+	Env []string
+	// Where to run the command, by default the current directory.
+	Dir string
+}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	// TODO control the environment
+func (task *CommandTask) Run(log TaskLog) bool {
+	return task.Execute(log) == Passed
+}
+
+func (task *CommandTask) Execute(log TaskLog) Outcome {
+	log.LogInfo("Running: %s", strings.Join(task.Args, " "))
+
+	cmd := exec.Command(task.Args[0], task.Args[1:]...)
+	cmd.Dir = task.Dir
+	if len(task.Env) > 0 {
+		cmd.Env = append(os.Environ(), task.Env...)
+	}
 	cmd.Stdout, cmd.Stderr = log.BeginCapture()
 	err := cmd.Run()
 	log.EndCapture()
 	if err == nil {
-		return true
-	} else {
-		log.LogError("Command failed: %s", err)
-		return false
+		return Passed
 	}
+	log.LogError("Command failed: %s", err)
+	exit, ok := err.(*exec.ExitError)
+	if ok && exit.ExitCode() >= 0 {
+		return Failed
+	}
+	// Not started, or killed by a signal.
+	return Interrupted
 }
 
-type CommandTask struct {
-	Args []string
+// Outcome tells a task that failed apart from one that never finished.
+type Outcome int
+
+const (
+	Passed Outcome = iota
+	// Ran to completion and failed, such as a command exiting non-zero.
+	Failed
+	// Did not run to completion, so the result says nothing about the
+	// task's inputs.
+	Interrupted
+)
+
+// Executor is implemented by tasks that can report an Outcome.
+type Executor interface {
+	TaskDecl
+	Execute(log TaskLog) Outcome
 }
 
-func (task *CommandTask) Run(log TaskLog) bool {
-	return RunCommand(task.Args, log)
+// Execute runs a task.  A task that is not an Executor is assumed to have
+// been interrupted if it fails.
+func Execute(task TaskDecl, log TaskLog) Outcome {
+	executor, ok := task.(Executor)
+	if ok {
+		return executor.Execute(log)
+	}
+	if task.Run(log) {
+		return Passed
+	}
+	return Interrupted
 }
@@ -0,0 +1,101 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// Leave the file alone if it already has the contents, so watchers are not
+// woken for nothing.
+func writeFileIfChanged(path string, data []byte, perm os.FileMode) error {
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+func CopyFile(src string, dst string) *FuncTask {
+	return Func(fmt.Sprintf("copy %s %s", src, dst), func(ctx context.Context, log TaskLog) error {
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return writeFileIfChanged(dst, data, info.Mode().Perm())
+	})
+}
+
+func MakeDir(path string) *FuncTask {
+	return Func(fmt.Sprintf("mkdir %s", path), func(ctx context.Context, log TaskLog) error {
+		return os.MkdirAll(path, 0777)
+	})
+}
+
+// Fail unless path exists and is a directory.
+func CheckDir(path string) *FuncTask {
+	return Func(fmt.Sprintf("check dir %s", path), func(ctx context.Context, log TaskLog) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+		return nil
+	})
+}
+
+// Write a file, such as a version stamp.
+func WriteFile(path string, contents string) *FuncTask {
+	return Func(fmt.Sprintf("write %s", path), func(ctx context.Context, log TaskLog) error {
+		return writeFileIfChanged(path, []byte(contents), 0666)
+	})
+}
+
+// Render a text/template file with data.
+func RenderTemplate(src string, dst string, data interface{}) *FuncTask {
+	return Func(fmt.Sprintf("render %s %s", src, dst), func(ctx context.Context, log TaskLog) error {
+		tmpl, err := template.ParseFiles(src)
+		if err != nil {
+			return err
+		}
+		buf := &bytes.Buffer{}
+		err = tmpl.Execute(buf, data)
+		if err != nil {
+			return err
+		}
+		return writeFileIfChanged(dst, buf.Bytes(), 0666)
+	})
+}
+
+// Write the SHA-256 of src to dst, in the format sha256sum uses.
+func WriteChecksum(src string, dst string) *FuncTask {
+	return Func(fmt.Sprintf("checksum %s %s", src, dst), func(ctx context.Context, log TaskLog) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		if err != nil {
+			return err
+		}
+		line := fmt.Sprintf("%x  %s\n", hash.Sum(nil), filepath.Base(src))
+		return writeFileIfChanged(dst, []byte(line), 0666)
+	})
+}
@@ -0,0 +1,32 @@
+package task
+
+import (
+	"context"
+)
+
+// FuncTask runs a Go function in place of a command.  Returning an error
+// fails the task.
+type FuncTask struct {
+	Name string
+	Func func(ctx context.Context, log TaskLog) error
+	// Passed to Func, context.Background() if nil.
+	Context context.Context
+}
+
+func (task *FuncTask) Run(log TaskLog) bool {
+	log.LogInfo("Running: %s", task.Name)
+	ctx := task.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err := task.Func(ctx, log)
+	if err != nil {
+		log.LogError("%s failed: %s", task.Name, err)
+		return false
+	}
+	return true
+}
+
+func Func(name string, fn func(ctx context.Context, log TaskLog) error) *FuncTask {
+	return &FuncTask{Name: name, Func: fn}
+}
@@ -20,11 +20,16 @@ type wrappedWriter struct {
 }
 
 func (w *wrappedWriter) Write(p []byte) (n int, err error) {
-	// TODO atomic write
-	w.Child.Write(w.Prefix)
-	n, err = w.Child.Write(p)
-	w.Child.Write(w.Postfix)
-	return
+	// Write everything at once so concurrent writers do not interleave.
+	buf := make([]byte, 0, len(w.Prefix)+len(p)+len(w.Postfix))
+	buf = append(buf, w.Prefix...)
+	buf = append(buf, p...)
+	buf = append(buf, w.Postfix...)
+	_, err = w.Child.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
 func MakeWrappedWriter(child io.Writer, prefix string, postfix string) io.Writer {
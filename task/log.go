@@ -1,11 +1,13 @@
 package task
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/mattn/go-colorable"
 	"github.com/mgutz/ansi"
 	"io"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -76,7 +78,10 @@ func (log *FlatTextLog) EndCapture() {
 }
 
 func (log *FlatTextLog) CreateSubtask(name string) TaskLog {
-	return &FlatTextLog{Parent: log, Path: append(log.Path, name), Printer: log.Printer}
+	// Copy the path so sibling subtasks do not share a backing array.
+	path := make([]string, len(log.Path), len(log.Path)+1)
+	copy(path, log.Path)
+	return &FlatTextLog{Parent: log, Path: append(path, name), Printer: log.Printer}
 }
 
 func (log *FlatTextLog) Begin(t time.Time) {
@@ -160,3 +165,57 @@ func (log *MultiLog) End(t time.Time, d time.Duration) {
 func MakeMultiLog(children ...TaskLog) TaskLog {
 	return &MultiLog{Children: children}
 }
+
+type lockedWriter struct {
+	mutex *sync.Mutex
+	child io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.child.Write(p)
+}
+
+// CaptureLog records the output of commands run by a task and its subtasks.
+type CaptureLog struct {
+	Stdout bytes.Buffer
+	Stderr bytes.Buffer
+	mutex  sync.Mutex
+}
+
+func (log *CaptureLog) LogInfo(format string, args ...interface{}) {
+}
+
+func (log *CaptureLog) LogError(format string, args ...interface{}) {
+}
+
+func (log *CaptureLog) BeginCapture() (io.Writer, io.Writer) {
+	return &lockedWriter{mutex: &log.mutex, child: &log.Stdout}, &lockedWriter{mutex: &log.mutex, child: &log.Stderr}
+}
+
+func (log *CaptureLog) EndCapture() {
+}
+
+func (log *CaptureLog) CreateSubtask(name string) TaskLog {
+	return log
+}
+
+func (log *CaptureLog) Begin(t time.Time) {
+}
+
+func (log *CaptureLog) End(t time.Time, d time.Duration) {
+}
+
+// MakeTextLog writes everything a task logs, uncolored, to a single writer.
+func MakeTextLog(w io.Writer) TaskLog {
+	w = &lockedWriter{mutex: &sync.Mutex{}, child: w}
+	return &FlatTextLog{
+		Printer: &FlatTextLogPrinter{
+			Stdout: w,
+			Stderr: w,
+			Info:   w,
+			Error:  w,
+		},
+	}
+}
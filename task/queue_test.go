@@ -1,12 +1,23 @@
 package task
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type TestTaskTrace struct {
 	NumTasks int
 	Trace    []int
+	mutex    sync.Mutex
 }
 
 func (trace *TestTaskTrace) MakeTask(ok bool) *TestTaskImpl {
@@ -15,14 +26,28 @@ func (trace *TestTaskTrace) MakeTask(ok bool) *TestTaskImpl {
 	return task
 }
 
+// Make a task that fails a number of times before succeeding.
+func (trace *TestTaskTrace) MakeFlakyTask(failures int) *TestTaskImpl {
+	task := trace.MakeTask(true)
+	task.Failures = failures
+	return task
+}
+
 type TestTaskImpl struct {
-	Trace *TestTaskTrace
-	UID   int
-	OK    bool
+	Trace    *TestTaskTrace
+	UID      int
+	OK       bool
+	Failures int
 }
 
 func (task *TestTaskImpl) Run(log TaskLog) bool {
+	task.Trace.mutex.Lock()
+	defer task.Trace.mutex.Unlock()
 	task.Trace.Trace = append(task.Trace.Trace, task.UID)
+	if task.Failures > 0 {
+		task.Failures -= 1
+		return false
+	}
 	return task.OK
 }
 
@@ -53,6 +78,110 @@ func TestBasicSanity(t *testing.T) {
 	runAndCheck(t0, trace, false, []int{0}, t)
 }
 
+func TestRetrySuccess(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Retry(trace.MakeTask(true), 3, 0)
+	runAndCheck(t0, trace, true, []int{0}, t)
+	if t0.Flaky() {
+		t.Fatal("not flaky")
+	}
+}
+
+func TestRetryFlaky(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Retry(trace.MakeFlakyTask(2), 3, 0)
+	runAndCheck(t0, trace, true, []int{0, 0, 0}, t)
+	if !t0.Flaky() {
+		t.Fatal("flaky")
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Retry(trace.MakeTask(false), 3, 0)
+	runAndCheck(t0, trace, false, []int{0, 0, 0}, t)
+	if t0.Flaky() {
+		t.Fatal("not flaky")
+	}
+}
+
+func TestSequence(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Sequence(trace.MakeTask(true), trace.MakeTask(true), trace.MakeTask(true))
+	runAndCheck(t0, trace, true, []int{0, 1, 2}, t)
+}
+
+func TestSequenceStops(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Sequence(trace.MakeTask(true), trace.MakeTask(false), trace.MakeTask(true))
+	runAndCheck(t0, trace, false, []int{0, 1}, t)
+}
+
+func TestAllOf(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := AllOf(trace.MakeTask(true), trace.MakeTask(false), trace.MakeTask(true))
+	runAndCheck(t0, trace, false, []int{0, 1, 2}, t)
+}
+
+func TestAnyOf(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := AnyOf(trace.MakeTask(false), trace.MakeTask(true), trace.MakeTask(false))
+	runAndCheck(t0, trace, true, []int{0, 1, 2}, t)
+}
+
+func TestAnyOfNone(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := AnyOf(trace.MakeTask(false), trace.MakeTask(false))
+	runAndCheck(t0, trace, false, []int{0, 1}, t)
+}
+
+func TestFallback(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Fallback(trace.MakeTask(false), trace.MakeTask(true), trace.MakeTask(true))
+	runAndCheck(t0, trace, true, []int{0, 1}, t)
+}
+
+func TestNested(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Sequence(
+		trace.MakeTask(true),
+		Fallback(trace.MakeTask(false), trace.MakeTask(true)),
+		trace.MakeTask(true),
+	)
+	runAndCheck(t0, trace, true, []int{0, 1, 2, 3}, t)
+}
+
+func TestParallel(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Parallel(trace.MakeTask(true), trace.MakeTask(true), trace.MakeTask(true))
+	log := &NullLog{}
+	if !t0.Run(log) {
+		t.Fatal("parallel failed")
+	}
+	sort.Ints(trace.Trace)
+	checkTrace([]int{0, 1, 2}, trace, t)
+}
+
+func TestParallelFailure(t *testing.T) {
+	trace := &TestTaskTrace{}
+	t0 := Parallel(trace.MakeTask(true), trace.MakeTask(false))
+	log := &NullLog{}
+	if t0.Run(log) {
+		t.Fatal("parallel succeeded")
+	}
+	sort.Ints(trace.Trace)
+	checkTrace([]int{0, 1}, trace, t)
+}
+
+func TestSubtaskPaths(t *testing.T) {
+	root := &FlatTextLog{Path: []string{"a", "b", "c"}}
+	x := root.CreateSubtask("x").(*FlatTextLog)
+	y := root.CreateSubtask("y").(*FlatTextLog)
+	if x.Path[3] != "x" || y.Path[3] != "y" {
+		t.Fatal(x.Path, y.Path)
+	}
+}
+
 func TestCommandTrue(t *testing.T) {
 	task := &CommandTask{
 		Args: []string{"true"},
@@ -64,6 +193,18 @@ func TestCommandTrue(t *testing.T) {
 	}
 }
 
+func TestCommandEnv(t *testing.T) {
+	task := &CommandTask{
+		Args: []string{"sh", "-c", "echo $CRANK_TEST"},
+		Env:  []string{"CRANK_TEST=hello"},
+	}
+	log := &CaptureLog{}
+	result := task.Run(log)
+	if !result || log.Stdout.String() != "hello\n" {
+		t.Fatal(result, log.Stdout.String())
+	}
+}
+
 func TestCommandFalse(t *testing.T) {
 	task := &CommandTask{
 		Args: []string{"false"},
@@ -74,3 +215,135 @@ func TestCommandFalse(t *testing.T) {
 		t.Fatal(task.Args)
 	}
 }
+
+func TestFuncTask(t *testing.T) {
+	called := false
+	task := Func("ok", func(ctx context.Context, log TaskLog) error {
+		called = true
+		return nil
+	})
+	if !task.Run(&NullLog{}) || !called {
+		t.Fatal(called)
+	}
+
+	task = Func("fail", func(ctx context.Context, log TaskLog) error {
+		return errors.New("fail")
+	})
+	if task.Run(&NullLog{}) {
+		t.Fatal("should fail")
+	}
+}
+
+func TestFileOps(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	copied := filepath.Join(dir, "out", "copy.txt")
+	rendered := filepath.Join(dir, "out", "rendered.txt")
+	sum := filepath.Join(dir, "out", "src.sha256")
+
+	err := os.WriteFile(src, []byte("hello {{.}}\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := Sequence(
+		MakeDir(filepath.Join(dir, "out")),
+		CheckDir(filepath.Join(dir, "out")),
+		CopyFile(src, copied),
+		RenderTemplate(src, rendered, "world"),
+		WriteChecksum(src, sum),
+	).Run(&NullLog{})
+	if !ok {
+		t.Fatal("file ops failed")
+	}
+
+	check := func(path string, expected string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatal(path, string(data))
+		}
+	}
+	check(copied, "hello {{.}}\n")
+	check(rendered, "hello world\n")
+	check(sum, "e0d7b29ab45effa2b6abfe6dd3fa68332b097904715722f8a44d41f25971de10  src.txt\n")
+}
+
+func TestCheckDirMissing(t *testing.T) {
+	if CheckDir(filepath.Join(t.TempDir(), "missing")).Run(&NullLog{}) {
+		t.Fatal("missing dir should fail")
+	}
+}
+
+func TestTextLog(t *testing.T) {
+	var buffer bytes.Buffer
+	log := MakeTextLog(&buffer).CreateSubtask("test")
+	task := &CommandTask{
+		Args: []string{"sh", "-c", "echo out; echo err 1>&2"},
+	}
+	log.Begin(time.Now())
+	task.Run(log)
+	log.LogError("failed")
+
+	text := buffer.String()
+	for _, expected := range []string{">>> test\n", "out\n", "err\n", "failed\n"} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("%#v not in %#v", expected, text)
+		}
+	}
+}
+
+func TestCommandDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pwd is not a command on Windows")
+	}
+	dir := t.TempDir()
+	task := &CommandTask{
+		Args: []string{"pwd"},
+		Dir:  dir,
+	}
+	log := &CaptureLog{}
+	result := task.Run(log)
+	resolved, _ := filepath.EvalSymlinks(dir)
+	if !result || strings.TrimSpace(log.Stdout.String()) != resolved {
+		t.Fatal(result, log.Stdout.String())
+	}
+}
+
+func TestCommandOutcome(t *testing.T) {
+	cases := []struct {
+		args    []string
+		outcome Outcome
+	}{
+		{[]string{"true"}, Passed},
+		{[]string{"false"}, Failed},
+		{[]string{"no-such-command-crank"}, Interrupted},
+		{[]string{"sh", "-c", "kill -INT $$"}, Interrupted},
+	}
+	for _, c := range cases {
+		outcome := Execute(&CommandTask{Args: c.args}, &NullLog{})
+		if outcome != c.outcome {
+			t.Fatal(c.args, outcome)
+		}
+	}
+}
+
+func TestRetryOutcome(t *testing.T) {
+	outcome := Execute(Retry(Command("no-such-command-crank"), 2, 0), &NullLog{})
+	if outcome != Interrupted {
+		t.Fatal(outcome)
+	}
+	outcome = Execute(Retry(Command("false"), 2, 0), &NullLog{})
+	if outcome != Failed {
+		t.Fatal(outcome)
+	}
+
+	// Tasks that cannot tell are assumed to be interrupted.
+	trace := &TestTaskTrace{}
+	outcome = Execute(Retry(trace.MakeTask(false), 2, 0), &NullLog{})
+	if outcome != Interrupted {
+		t.Fatal(outcome)
+	}
+}
@@ -0,0 +1,58 @@
+package task
+
+import (
+	"fmt"
+	"time"
+)
+
+// FlakyTask is implemented by tasks that can tell whether their last run only
+// succeeded after failing.
+type FlakyTask interface {
+	TaskDecl
+	Flaky() bool
+}
+
+// RetryTask reruns a failing task, doubling the backoff between attempts.
+// Each attempt is logged as its own subtask.
+type RetryTask struct {
+	Task        TaskDecl
+	MaxAttempts int
+	Backoff     time.Duration
+	flaky       bool
+}
+
+func (task *RetryTask) Run(log TaskLog) bool {
+	return task.Execute(log) == Passed
+}
+
+// The outcome is that of the last attempt.
+func (task *RetryTask) Execute(log TaskLog) Outcome {
+	task.flaky = false
+	delay := task.Backoff
+	for attempt := 1; ; attempt++ {
+		outcome := executeSubtask(log, fmt.Sprintf("attempt %d", attempt), task.Task)
+		if outcome == Passed {
+			if attempt > 1 {
+				task.flaky = true
+				log.LogError("Flaky: passed on attempt %d of %d", attempt, task.MaxAttempts)
+			}
+			return Passed
+		}
+		if attempt >= task.MaxAttempts {
+			return outcome
+		}
+		if delay > 0 {
+			log.LogInfo("Retrying in %s", delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (task *RetryTask) Flaky() bool {
+	return task.flaky
+}
+
+func Retry(task TaskDecl, maxAttempts int, backoff time.Duration) *RetryTask {
+	return &RetryTask{Task: task, MaxAttempts: maxAttempts, Backoff: backoff}
+}
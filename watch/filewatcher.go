@@ -1,8 +1,10 @@
 package watch
 
 import (
+	"context"
 	"github.com/rjeczalik/notify"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -37,15 +39,21 @@ func Rel(basepath string, child FileObserver) FileObserver {
 	return &relWrapper{basepath: basepath, child: child}
 }
 
-func debounce() (chan<- bool, <-chan bool) {
+func debounce(ctx context.Context, wg *sync.WaitGroup) (chan<- bool, <-chan bool) {
 	beginDebounce := make(chan bool, 1)
 	endDebounce := make(chan bool, 1)
 
 	debounceDuration := time.Duration(1) * time.Second
 
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
 		for {
-			<-beginDebounce
+			select {
+			case <-beginDebounce:
+			case <-ctx.Done():
+				return
+			}
 			quiet := time.After(debounceDuration)
 			waiting := true
 			for waiting {
@@ -54,31 +62,131 @@ func debounce() (chan<- bool, <-chan bool) {
 					quiet = time.After(debounceDuration)
 				case <-quiet:
 					waiting = false
+				case <-ctx.Done():
+					return
 				}
 			}
-			endDebounce <- true
+			select {
+			case endDebounce <- true:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 	return beginDebounce, endDebounce
 }
 
-func WatchFiles(path string, observer FileObserver) error {
-	fileWatcher := make(chan notify.EventInfo, 1)
-	err := notify.Watch(path, fileWatcher, notify.All)
+// Root is a path to watch.  If Rel is set, paths under the root are made
+// relative to it before they are passed to the observer.
+type Root struct {
+	Path      string
+	Recursive bool
+	Rel       string
+}
+
+func (r *Root) notifyPath() string {
+	if r.Recursive {
+		return filepath.Join(r.Path, "...")
+	}
+	return r.Path
+}
+
+func (r *Root) observedPath(path string) (string, bool) {
+	if r.Rel == "" {
+		return path, true
+	}
+	path, err := filepath.Rel(r.Rel, path)
 	if err != nil {
-		return err
+		return "", false
+	}
+	return path, true
+}
+
+// Parse a notify-style path, where a trailing "..." means recursive.
+func parseRoot(path string) Root {
+	if filepath.Base(path) == "..." {
+		return Root{Path: filepath.Dir(path), Recursive: true}
+	}
+	return Root{Path: path}
+}
+
+// WatchFiles watches path forever, see WatchFilesContext.
+func WatchFiles(path string, observer FileObserver) error {
+	return WatchFilesContext(context.Background(), path, observer)
+}
+
+// WatchFilesContext watches path until ctx is cancelled, then stops the watch
+// and returns nil.  The observer is only called from the calling goroutine.
+func WatchFilesContext(ctx context.Context, path string, observer FileObserver) error {
+	return WatchRoots(ctx, []Root{parseRoot(path)}, observer)
+}
+
+// WatchRoots is WatchFilesContext for several roots, merging their events
+// into a single observer.
+func WatchRoots(ctx context.Context, roots []Root, observer FileObserver) error {
+	return WatchRootsControl(ctx, roots, observer, nil)
+}
+
+// WatchRootsControl is WatchRoots that also calls the functions sent on
+// control, from the same goroutine as the observer, so they can safely
+// change its state.  Nothing it started is left running once it returns.
+func WatchRootsControl(ctx context.Context, roots []Root, observer FileObserver, control <-chan func()) error {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	changed := make(chan string)
+	for i := range roots {
+		root := &roots[i]
+		fileWatcher := make(chan notify.EventInfo, 1)
+		err := notify.Watch(root.notifyPath(), fileWatcher, notify.All)
+		if err != nil {
+			return err
+		}
+		defer notify.Stop(fileWatcher)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case evt := <-fileWatcher:
+					path, ok := root.observedPath(evt.Path())
+					if !ok {
+						continue
+					}
+					select {
+					case changed <- path:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
 	}
-	defer notify.Stop(fileWatcher)
-	beginDebounce, endDebounce := debounce()
+
+	beginDebounce, endDebounce := debounce(ctx, wg)
+
 	observer.Begin()
 	for {
 		select {
-		case evt := <-fileWatcher:
-			if observer.FileChanged(evt.Path()) {
-				beginDebounce <- true
+		case path := <-changed:
+			if observer.FileChanged(path) {
+				select {
+				case beginDebounce <- true:
+				default:
+					// A debounce is already queued.
+				}
 			}
 		case <-endDebounce:
 			observer.Idle()
+		case f := <-control:
+			f()
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
@@ -0,0 +1,139 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Records what the watcher calls, as it is called.
+type testObserver struct {
+	begin   chan bool
+	changed chan string
+	idle    chan bool
+}
+
+func makeTestObserver() *testObserver {
+	return &testObserver{
+		begin:   make(chan bool, 1),
+		changed: make(chan string, 100),
+		idle:    make(chan bool, 100),
+	}
+}
+
+func (o *testObserver) Begin() {
+	o.begin <- true
+}
+
+func (o *testObserver) FileChanged(path string) bool {
+	o.changed <- path
+	return true
+}
+
+func (o *testObserver) Idle() {
+	o.idle <- true
+}
+
+// Run a watch in the background, returning a channel for its result.
+func startWatch(ctx context.Context, dir string, observer *testObserver, t *testing.T) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchFilesContext(ctx, filepath.Join(dir, "..."), observer)
+	}()
+	select {
+	case <-observer.begin:
+	case err := <-done:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not begin")
+	}
+	return done
+}
+
+func waitReturn(done <-chan error, t *testing.T) {
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not return after cancel")
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	dir := t.TempDir()
+
+	// notify starts goroutines of its own on first use, which outlive any
+	// one watch, so count after a warm up.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startWatch(ctx, dir, makeTestObserver(), t)
+	cancel()
+	waitReturn(done, t)
+	before := runtime.NumGoroutine()
+
+	observer := makeTestObserver()
+	ctx, cancel = context.WithCancel(context.Background())
+	done = startWatch(ctx, dir, observer, t)
+
+	// Leave a debounce in progress when cancelling.
+	err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-observer.changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change seen")
+	}
+	cancel()
+	waitReturn(done, t)
+
+	// The watch waits for its goroutines before returning.
+	after := runtime.NumGoroutine()
+	if after > before {
+		t.Fatal(before, after)
+	}
+	select {
+	case <-observer.idle:
+		t.Fatal("idle after cancel")
+	default:
+	}
+}
+
+func TestWatchIdle(t *testing.T) {
+	dir := t.TempDir()
+	observer := makeTestObserver()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := startWatch(ctx, dir, observer, t)
+
+	err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-observer.idle:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not idle after a change")
+	}
+	cancel()
+	waitReturn(done, t)
+}
+
+func TestParseRoot(t *testing.T) {
+	root := parseRoot(filepath.Join("a", "b", "..."))
+	if root.Path != filepath.Join("a", "b") || !root.Recursive {
+		t.Fatal(root)
+	}
+	root = parseRoot(filepath.Join("a", "b"))
+	if root.Path != filepath.Join("a", "b") || root.Recursive {
+		t.Fatal(root)
+	}
+	if root.notifyPath() != filepath.Join("a", "b") {
+		t.Fatal(root.notifyPath())
+	}
+}
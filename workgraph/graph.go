@@ -34,6 +34,20 @@ type Node struct {
 	live      bool
 	Prev      *Node
 	Next      *Node
+	// Marked live itself, rather than for a node that depends on it.
+	target bool
+}
+
+func (n *Node) State() NodeState {
+	return n.state
+}
+
+func (n *Node) Live() bool {
+	return n.live
+}
+
+func (n *Node) Target() bool {
+	return n.target
 }
 
 func (n *Node) Ready() bool {
@@ -62,6 +76,9 @@ type WorkGraph struct {
 	Tail      *Node
 	LiveNodes NodeCounts
 	DeadNodes NodeCounts
+	// Only live nodes become pending.  Otherwise dead nodes run too, once
+	// nothing holds them back.  UnmarkLive sets it.
+	LiveOnly bool
 }
 
 func (g *WorkGraph) adjustCount(state NodeState, live bool, amt int, waitCount int) {
@@ -183,12 +200,12 @@ func (g *WorkGraph) dequeuePending(n *Node) {
 func (g *WorkGraph) adjustPending(n *Node) {
 	switch n.state {
 	case WAITING:
-		if n.waitCount == 0 {
+		if n.waitCount == 0 && (n.live || !g.LiveOnly) {
 			g.setState(n, PENDING)
 			g.appendPending(n)
 		}
 	case PENDING:
-		if n.waitCount != 0 || !n.live {
+		if n.waitCount != 0 || (!n.live && g.LiveOnly) {
 			g.dequeuePending(n)
 			g.setState(n, WAITING)
 		}
@@ -248,16 +265,58 @@ func (g *WorkGraph) markError(n *Node) {
 	g.markComplete(n, ERROR)
 }
 
+// MarkLive makes n a target, so it and everything it depends on will run.
 func (g *WorkGraph) MarkLive(n *Node) {
+	n.target = true
+	g.markLive(n)
+}
+
+func (g *WorkGraph) markLive(n *Node) {
 	if !n.live {
 		g.setLive(n, true)
 		g.adjustPending(n)
 		for _, e := range n.Srcs {
-			g.MarkLive(e.Src)
+			g.markLive(e.Src)
 		}
 	}
 }
 
+// UnmarkLive stops n being a target.  It and its dependencies stay live only
+// while another target needs them.
+func (g *WorkGraph) UnmarkLive(n *Node) {
+	if !g.LiveOnly {
+		g.LiveOnly = true
+		// Dead nodes already queued stop being pending.
+		pending := []*Node{}
+		for p := g.Head; p != nil; p = p.Next {
+			pending = append(pending, p)
+		}
+		for _, p := range pending {
+			g.adjustPending(p)
+		}
+	}
+	n.target = false
+	g.refreshLive(n)
+}
+
+func (g *WorkGraph) refreshLive(n *Node) {
+	live := n.target
+	for _, e := range n.Dsts {
+		if e.Dst.live {
+			live = true
+			break
+		}
+	}
+	if live == n.live {
+		return
+	}
+	g.setLive(n, live)
+	g.adjustPending(n)
+	for _, e := range n.Srcs {
+		g.refreshLive(e.Src)
+	}
+}
+
 func (g *WorkGraph) beginRunning(n *Node) {
 	g.dequeuePending(n)
 	g.setState(n, RUNNING)
@@ -274,3 +333,38 @@ func (g *WorkGraph) Run() {
 		}
 	}
 }
+
+// RunParallel is Run with up to jobs nodes running at once.  Work.Run is
+// called from other goroutines, but only the caller touches the graph.
+func (g *WorkGraph) RunParallel(jobs int) {
+	if jobs <= 1 {
+		g.Run()
+		return
+	}
+	type result struct {
+		node *Node
+		ok   bool
+	}
+	done := make(chan result)
+	running := 0
+	for {
+		for g.Head != nil && running < jobs {
+			current := g.Head
+			g.beginRunning(current)
+			running += 1
+			go func(n *Node) {
+				done <- result{node: n, ok: n.Work.Run()}
+			}(current)
+		}
+		if running == 0 {
+			return
+		}
+		r := <-done
+		running -= 1
+		if r.ok {
+			g.markSuccess(r.node)
+		} else {
+			g.markError(r.node)
+		}
+	}
+}
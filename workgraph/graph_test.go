@@ -2,12 +2,14 @@ package workgraph
 
 import (
 	"github.com/stretchr/testify/assert"
+	"sync"
 	"testing"
 )
 
 type FakeWorkManager struct {
 	Trace      []int
 	CurrentUID int
+	mutex      sync.Mutex
 }
 
 func (m *FakeWorkManager) Create(result bool) *FakeWork {
@@ -26,6 +28,8 @@ func (w *FakeWork) Invalidated() {
 }
 
 func (w *FakeWork) Run() bool {
+	w.Manager.mutex.Lock()
+	defer w.Manager.mutex.Unlock()
 	w.Manager.Trace = append(w.Manager.Trace, w.UID)
 	return w.Result
 }
@@ -308,3 +312,145 @@ func TestNodeReadyThreeInARow(t *testing.T) {
 	g.Run()
 	assert.Equal(t, []int{0, 1, 2}, m.Trace)
 }
+
+func indexOf(trace []int, uid int) int {
+	for i, other := range trace {
+		if other == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestRunParallelDiamond(t *testing.T) {
+	m := &FakeWorkManager{}
+	g := &WorkGraph{}
+	n0 := g.CreateNode(m.Create(true))
+	n1 := g.CreateNode(m.Create(true))
+	n2 := g.CreateNode(m.Create(false))
+	n3 := g.CreateNode(m.Create(true))
+	g.CreateEdge(n0, n1, false)
+	g.CreateEdge(n0, n2, false)
+	g.CreateEdge(n1, n3, false)
+	g.CreateEdge(n2, n3, true)
+	g.MarkLive(n3)
+
+	g.RunParallel(4)
+
+	assert.Equal(t, SUCCESS, n0.state)
+	assert.Equal(t, SUCCESS, n1.state)
+	assert.Equal(t, ERROR, n2.state)
+	assert.Equal(t, SUCCESS, n3.state)
+	checkCounts(t, g, NodeCounts{0, 0, 0, 3, 1, 0}, NodeCounts{})
+
+	assert.Equal(t, 4, len(m.Trace))
+	assert.Equal(t, 0, indexOf(m.Trace, 0))
+	assert.Equal(t, 3, indexOf(m.Trace, 3))
+}
+
+func TestRunParallelBlocked(t *testing.T) {
+	m := &FakeWorkManager{}
+	g := &WorkGraph{}
+	n0 := g.CreateNode(m.Create(false))
+	n1 := g.CreateNode(m.Create(true))
+	n2 := g.CreateNode(m.Create(true))
+	g.CreateEdge(n0, n2, false)
+	g.CreateEdge(n1, n2, false)
+	g.MarkLive(n2)
+
+	g.RunParallel(2)
+
+	assert.Equal(t, ERROR, n0.state)
+	assert.Equal(t, SUCCESS, n1.state)
+	assert.Equal(t, WAITING, n2.state)
+	assert.Equal(t, 2, len(m.Trace))
+}
+
+func TestDeadNodesRun(t *testing.T) {
+	m := &FakeWorkManager{}
+	g := &WorkGraph{}
+	n0 := g.CreateNode(m.Create(true))
+	n1 := g.CreateNode(m.Create(true))
+	g.CreateEdge(n0, n1, false)
+	g.MarkLive(n0)
+
+	g.Run()
+	assert.Equal(t, SUCCESS, n0.state)
+	assert.Equal(t, SUCCESS, n1.state)
+	checkCounts(t, g, NodeCounts{0, 0, 0, 1, 0, 0}, NodeCounts{0, 0, 0, 1, 0, 0})
+	assert.Equal(t, []int{0, 1}, m.Trace)
+}
+
+func TestDeadNodesDoNotRun(t *testing.T) {
+	m := &FakeWorkManager{}
+	g := &WorkGraph{LiveOnly: true}
+	n0 := g.CreateNode(m.Create(true))
+	n1 := g.CreateNode(m.Create(true))
+	g.CreateEdge(n0, n1, false)
+	g.MarkLive(n0)
+
+	g.Run()
+	assert.Equal(t, SUCCESS, n0.state)
+	assert.Equal(t, WAITING, n1.state)
+	checkCounts(t, g, NodeCounts{0, 0, 0, 1, 0, 0}, NodeCounts{1, 0, 0, 0, 0, 0})
+	assert.Equal(t, []int{0}, m.Trace)
+}
+
+func TestUnmarkLive(t *testing.T) {
+	m := &FakeWorkManager{}
+	g := &WorkGraph{}
+	n0 := g.CreateNode(m.Create(true))
+	n1 := g.CreateNode(m.Create(true))
+	n2 := g.CreateNode(m.Create(true))
+	g.CreateEdge(n0, n1, false)
+	g.CreateEdge(n0, n2, true)
+	g.MarkLive(n1)
+	g.MarkLive(n2)
+	assert.True(t, n1.Target())
+	assert.False(t, n0.Target())
+
+	// n0 is still needed by n2.
+	g.UnmarkLive(n1)
+	assert.False(t, n1.Live())
+	assert.True(t, n0.Live())
+	assert.True(t, n2.Live())
+	checkCounts(t, g, NodeCounts{1, 1, 0, 0, 0, 1}, NodeCounts{1, 0, 0, 0, 0, 1})
+
+	// Nothing is live, so nothing is pending.
+	g.UnmarkLive(n2)
+	assert.False(t, n0.Live())
+	assert.Equal(t, WAITING, n0.State())
+	checkCounts(t, g, NodeCounts{}, NodeCounts{3, 0, 0, 0, 0, 2})
+	g.Run()
+	assert.Equal(t, 0, len(m.Trace))
+
+	g.MarkLive(n1)
+	g.Run()
+	assert.Equal(t, []int{0, 1}, m.Trace)
+	assert.Equal(t, SUCCESS, n1.State())
+	assert.Equal(t, WAITING, n2.State())
+
+	// A target that already ran stays done when it is unmarked and marked.
+	g.UnmarkLive(n1)
+	g.MarkLive(n1)
+	g.Run()
+	assert.Equal(t, []int{0, 1}, m.Trace)
+	checkCounts(t, g, NodeCounts{0, 0, 0, 2, 0, 0}, NodeCounts{1, 0, 0, 0, 0, 0})
+}
+
+func TestUnmarkLiveDequeuesDeadNodes(t *testing.T) {
+	m := &FakeWorkManager{}
+	g := &WorkGraph{}
+	n0 := g.CreateNode(m.Create(true))
+	n1 := g.CreateNode(m.Create(true))
+	g.CreateEdge(n0, n1, false)
+	g.MarkLive(n0)
+	g.markSuccess(n0)
+	assert.Equal(t, PENDING, n1.State())
+
+	g.UnmarkLive(n0)
+	assert.True(t, g.LiveOnly)
+	assert.Equal(t, WAITING, n1.State())
+	g.Run()
+	assert.Equal(t, 0, len(m.Trace))
+}